@@ -0,0 +1,102 @@
+// Package backend defines the interface between hoser and the runtimes that execute a plan. A runtime turns a
+// plan.Pipe into a Program which can then be started, waited on and stopped. The default runtime runs each process
+// as an OS process (see osruntime), but other runtimes can be registered under their own name and selected with
+// hoser -runtime.
+package backend
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/masp/hoser-runtime/plan"
+)
+
+// Runtime builds programs for a single execution backend.
+type Runtime interface {
+	// Build creates a program from pipe without starting it. The presets bind values to variables by name, e.g.
+	// stdin to os.Stdin.
+	Build(pipe plan.Pipe, varPresets map[string]any) (Program, error)
+}
+
+// Program is a built pipe ready to be executed by its runtime.
+type Program interface {
+	// Start starts every process of the program. It can only be called once.
+	Start() error
+	// Wait blocks until every process has exited. The error describes the first process that failed, if any.
+	Wait() error
+	// Stop asks every running process to exit. Wait still must be called to wait for them to finish.
+	Stop() error
+	// Stats returns a snapshot of the state of each process sorted by name.
+	Stats() []ProcStats
+}
+
+type State string
+
+const (
+	StateCreated State = "created"
+	StateRunning State = "running"
+	StateExited  State = "exited"
+	StateFailed  State = "failed" // The process could not be started or exited with a non-zero code
+)
+
+// ProcStats is a snapshot of a single process of a program.
+type ProcStats struct {
+	Name     string    `json:"name"`
+	State    State     `json:"state"`
+	Pid      int       `json:"pid,omitempty"` // 0 if the process is not an OS process or not started
+	ExitCode int       `json:"exit_code"`
+	Started  time.Time `json:"started,omitempty"`
+	Exited   time.Time `json:"exited,omitempty"`
+
+	// Progress and errors reported by the process itself, if it supports reporting (see package node)
+	Records   int64  `json:"records,omitempty"`
+	Bytes     int64  `json:"bytes,omitempty"`
+	Queued    int64  `json:"queued,omitempty"` // Records buffered inside the process, e.g. by a buffer
+	Errors    int    `json:"errors,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+var (
+	mu       sync.Mutex
+	runtimes = make(map[string]Runtime)
+)
+
+// Register makes a runtime available under name. It panics if the name is already registered, it is
+// intended to be called from the init function of the package implementing the runtime.
+func Register(name string, rt Runtime) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := runtimes[name]; ok {
+		panic("backend: runtime registered twice: " + name)
+	}
+	runtimes[name] = rt
+}
+
+// Lookup returns the runtime registered as name.
+func Lookup(name string) (Runtime, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	rt, ok := runtimes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown runtime '%s' (available: %v)", name, names())
+	}
+	return rt, nil
+}
+
+// Names returns the names of all registered runtimes in sorted order.
+func Names() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	return names()
+}
+
+func names() []string {
+	var list []string
+	for name := range runtimes {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
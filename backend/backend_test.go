@@ -0,0 +1,150 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/masp/hoser-runtime/plan"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// goRuntime runs every process as a goroutine calling the function named by its exe.
+type goRuntime map[string]func(ctx context.Context) error
+
+type goProgram struct {
+	funcs    map[string]func(ctx context.Context) error
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	stats    map[string]*ProcStats
+	failures []string
+}
+
+func (rt goRuntime) Build(pipe plan.Pipe, varPresets map[string]any) (Program, error) {
+	prog := &goProgram{funcs: make(map[string]func(ctx context.Context) error), stats: make(map[string]*ProcStats)}
+	for _, proc := range pipe.Procs {
+		fn, ok := rt[proc.Exe]
+		if !ok {
+			return nil, fmt.Errorf("unknown function '%s'", proc.Exe)
+		}
+		prog.funcs[proc.Name] = fn
+		prog.stats[proc.Name] = &ProcStats{Name: proc.Name, State: StateCreated}
+	}
+	prog.ctx, prog.cancel = context.WithCancel(context.Background())
+	return prog, nil
+}
+
+func (p *goProgram) Start() error {
+	for name, fn := range p.funcs {
+		p.setState(name, StateRunning, 0)
+		p.wg.Add(1)
+		go func(name string, fn func(ctx context.Context) error) {
+			defer p.wg.Done()
+			if err := fn(p.ctx); err != nil {
+				p.setState(name, StateFailed, 1)
+				return
+			}
+			p.setState(name, StateExited, 0)
+		}(name, fn)
+	}
+	return nil
+}
+
+func (p *goProgram) setState(name string, state State, code int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats[name].State, p.stats[name].ExitCode = state, code
+	if state == StateFailed {
+		p.failures = append(p.failures, name)
+	}
+}
+
+func (p *goProgram) Wait() error {
+	p.wg.Wait()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.failures) > 0 {
+		return fmt.Errorf("process '%s' failed", p.failures[0])
+	}
+	return nil
+}
+
+func (p *goProgram) Stop() error {
+	p.cancel()
+	return nil
+}
+
+func (p *goProgram) Stats() []ProcStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var stats []ProcStats
+	for _, stat := range p.stats {
+		stats = append(stats, *stat)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// register registers a runtime for the duration of the test.
+func register(t *testing.T, name string, rt Runtime) {
+	Register(name, rt)
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(runtimes, name)
+	})
+}
+
+func TestRegister(t *testing.T) {
+	register(t, "test-a", goRuntime{})
+	register(t, "test-b", goRuntime{})
+	assert.Subset(t, Names(), []string{"test-a", "test-b"})
+	assert.True(t, sort.StringsAreSorted(Names()))
+	assert.Panics(t, func() { Register("test-a", goRuntime{}) })
+
+	rt, err := Lookup("test-b")
+	require.NoError(t, err)
+	assert.NotNil(t, rt)
+	_, err = Lookup("test-missing")
+	assert.ErrorContains(t, err, "test-a")
+}
+
+// TestSecondBackend runs a pipe on a backend that is not osruntime, only through the interfaces.
+func TestSecondBackend(t *testing.T) {
+	register(t, "test-go", goRuntime{
+		"ok":   func(ctx context.Context) error { return nil },
+		"fail": func(ctx context.Context) error { return errors.New("failed") },
+		"wait": func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		},
+	})
+	rt, err := Lookup("test-go")
+	require.NoError(t, err)
+
+	proc := func(name, exe string) plan.Process {
+		return plan.Process{Node: plan.Node{Name: name}, Exe: exe}
+	}
+	_, err = rt.Build(plan.Pipe{Procs: []plan.Process{proc("a", "nope")}}, nil)
+	assert.Error(t, err)
+
+	prog, err := rt.Build(plan.Pipe{Procs: []plan.Process{proc("a", "ok"), proc("b", "wait"), proc("c", "fail")}}, nil)
+	require.NoError(t, err)
+	for _, stat := range prog.Stats() {
+		assert.Equal(t, StateCreated, stat.State)
+	}
+	require.NoError(t, prog.Start())
+	assert.Eventually(t, func() bool { return prog.Stats()[2].State == StateFailed }, time.Second, time.Millisecond)
+	assert.Equal(t, StateRunning, prog.Stats()[1].State)
+	require.NoError(t, prog.Stop())
+	assert.EqualError(t, prog.Wait(), "process 'c' failed")
+	assert.Equal(t, []State{StateExited, StateExited, StateFailed},
+		[]State{prog.Stats()[0].State, prog.Stats()[1].State, prog.Stats()[2].State})
+}
@@ -0,0 +1,48 @@
+package builtin
+
+import (
+	"context"
+	"flag"
+
+	"github.com/masp/hoser-runtime/node"
+	"github.com/masp/hoser-runtime/plan"
+	"github.com/masp/hoser-runtime/spill"
+)
+
+// DefaultBufferSize is the memory a buffer uses before it spills to disk, if not given.
+const DefaultBufferSize = 64 << 20
+
+func init() {
+	Register("buffer", Buffer, plan.Manifest{
+		In:  []plan.Port{{Name: "stdin", Type: plan.TypeStream}},
+		Out: []plan.Port{{Name: "stdout", Type: plan.TypeStream}},
+		Flags: []plan.FlagSpec{
+			{Name: "mem", Type: "int", Default: "67108864", Usage: "the bytes of records kept in memory before spilling to disk"},
+			{Name: "spill-dir", Type: "string", Usage: "directory for the spill file"},
+			{Name: "framing", Type: "value", Default: "delim", Usage: "how records are framed: delim, length or jsonl"},
+			{Name: "sep", Type: "value", Default: "\n", Usage: "with -framing delim, records are terminated by this string"},
+		},
+	})
+}
+
+// Buffer copies stdin to stdout through a queue that grows in memory up to -mem bytes and then spills to disk, so
+// the process writing stdin never waits on the one reading stdout. The number of queued records is reported as
+// progress. It is the in-process equivalent of hoser-buffer and what the runtime inserts for links with a buffer.
+//
+//	builtin:buffer [-mem bytes] [-spill-dir dir] [-framing f] [-sep s]
+func Buffer(ctx context.Context, env *Env) error {
+	flags := flag.NewFlagSet(env.Name, flag.ContinueOnError)
+	flags.SetOutput(env.Stderr)
+	mem := flags.Int64("mem", DefaultBufferSize, "the bytes of records kept in memory before spilling to disk")
+	spillDir := flags.String("spill-dir", "", "directory for the spill file")
+	framing := node.AddFramingFlags(flags)
+	if err := flags.Parse(env.Args); err != nil {
+		return err
+	}
+
+	q := spill.New(*mem, *spillDir)
+	defer q.Release()
+	rd := (*framing).NewReader(env.Stdin)
+	wr := (*framing).NewWriter(env.Stdout)
+	return spill.Pump(ctx, rd, wr, q, env.Progress)
+}
@@ -0,0 +1,154 @@
+// Package builtin is a registry of nodes implemented in Go that the runtime runs in-process as goroutines instead
+// of exec'ing a separate binary. A process selects a builtin with an Exe of the form "builtin:name".
+//
+// A builtin sees the same invocation as an OS process: its arguments are resolved the same way and stream ports
+// passed as arguments appear as /dev/fd/N, which Env.Open and Env.Create map back to the file of the link.
+package builtin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/masp/hoser-runtime/node"
+	"github.com/masp/hoser-runtime/plan"
+)
+
+// Prefix is the prefix of the Exe of a process that should run a builtin.
+const Prefix = "builtin:"
+
+// Env is the environment a builtin runs in, the equivalent of argv and the file descriptors of an OS process.
+type Env struct {
+	Name       string   // The name of the builtin (without Prefix)
+	Args       []string // The arguments, not including the name
+	Stdin      io.Reader
+	Stdout     io.Writer
+	Stderr     io.Writer
+	ExtraFiles []*os.File // ExtraFiles[i] is /dev/fd/3+i, like exec.Cmd
+
+	// Report records an event in the stats of the process, like an OS process reporting over HOSER_REPORT_FD. It
+	// may be nil.
+	Report func(node.Event)
+}
+
+// Func runs a builtin to completion. The context is cancelled when the program is stopped.
+type Func func(ctx context.Context, env *Env) error
+
+type entry struct {
+	fn       Func
+	manifest plan.Manifest
+}
+
+var (
+	mu       sync.Mutex
+	builtins = make(map[string]entry)
+)
+
+// Register makes a builtin available as "builtin:name". The manifest describes its ports like --hoser-describe
+// does for executables.
+func Register(name string, fn Func, manifest plan.Manifest) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := builtins[name]; ok {
+		panic("builtin: registered twice: " + name)
+	}
+	manifest.Name = Prefix + name
+	if manifest.Flags == nil {
+		manifest.Flags = []plan.FlagSpec{}
+	}
+	builtins[name] = entry{fn: fn, manifest: manifest}
+}
+
+// Lookup returns the builtin for an Exe, which may or may not include Prefix.
+func Lookup(exe string) (Func, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	e, ok := builtins[strings.TrimPrefix(exe, Prefix)]
+	return e.fn, ok
+}
+
+// Describe returns the manifest of the builtin for an Exe.
+func Describe(exe string) (plan.Manifest, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	e, ok := builtins[strings.TrimPrefix(exe, Prefix)]
+	return e.manifest, ok
+}
+
+// Names returns the names of all registered builtins in sorted order.
+func Names() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	var names []string
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsBuiltin reports whether exe refers to a builtin.
+func IsBuiltin(exe string) bool {
+	return strings.HasPrefix(exe, Prefix)
+}
+
+// Open opens an input argument. /dev/fd/N refers to the stream port passed as that fd, "-" to stdin and any other
+// path is opened from the filesystem.
+func (e *Env) Open(name string) (io.ReadCloser, error) {
+	if name == "-" || name == "/dev/fd/0" || name == "/dev/stdin" {
+		return io.NopCloser(e.Stdin), nil
+	}
+	if fd, ok := e.fd(name); ok {
+		return io.NopCloser(fd), nil // the runtime closes the ports once the builtin returns
+	}
+	return os.Open(name)
+}
+
+// Create opens an output argument. /dev/fd/N refers to the stream port passed as that fd, "-" to stdout and any
+// other path is created on the filesystem.
+func (e *Env) Create(name string) (io.WriteCloser, error) {
+	switch name {
+	case "-", "/dev/fd/1", "/dev/stdout":
+		return nopWriteCloser{e.Stdout}, nil
+	case "/dev/fd/2", "/dev/stderr":
+		return nopWriteCloser{e.Stderr}, nil
+	}
+	if fd, ok := e.fd(name); ok {
+		return nopWriteCloser{fd}, nil
+	}
+	return os.Create(name)
+}
+
+func (e *Env) fd(name string) (*os.File, bool) {
+	if !strings.HasPrefix(name, "/dev/fd/") {
+		return nil, false
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(name, "/dev/fd/"))
+	if err != nil || n < 3 || n-3 >= len(e.ExtraFiles) {
+		return nil, false
+	}
+	return e.ExtraFiles[n-3], true
+}
+
+// Progress reports the records and bytes processed so far and how many records are queued, see node.Event.
+func (e *Env) Progress(records, bytes, queued int64) {
+	if e.Report != nil {
+		e.Report(node.Event{Kind: node.EventProgress, Records: records, Bytes: bytes, Queued: queued})
+	}
+}
+
+// Logf writes a message to the stderr of the builtin, prefixed with its name.
+func (e *Env) Logf(format string, args ...any) {
+	fmt.Fprintf(e.Stderr, "%s: %s\n", e.Name, fmt.Sprintf(format, args...))
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,86 @@
+package builtin
+
+import (
+	"context"
+	"flag"
+	"io"
+	"sync"
+
+	"github.com/masp/hoser-runtime/node"
+	"github.com/masp/hoser-runtime/plan"
+)
+
+func init() {
+	Register("merge", Merge, plan.Manifest{
+		In:   []plan.Port{{Name: "inputs", Type: plan.TypeStream}},
+		Out:  []plan.Port{{Name: "stdout", Type: plan.TypeStream}},
+		Args: []plan.ArgSpec{{Name: "inputs", Variadic: true}},
+		Flags: []plan.FlagSpec{
+			{Name: "framing", Type: "value", Default: "delim", Usage: "how records are framed: delim, length or jsonl"},
+			{Name: "sep", Type: "value", Default: "\n", Usage: "with -framing delim, records are terminated by this string"},
+		},
+	})
+}
+
+// Merge copies records from every input argument to stdout. Records are written atomically, so records from
+// different inputs are never interleaved. It is the in-process equivalent of hoser-merge with static inputs.
+//
+//	builtin:merge [-framing f] [-sep s] input...
+func Merge(ctx context.Context, env *Env) error {
+	flags := flag.NewFlagSet(env.Name, flag.ContinueOnError)
+	flags.SetOutput(env.Stderr)
+	framing := node.AddFramingFlags(flags)
+	if err := flags.Parse(env.Args); err != nil {
+		return err
+	}
+
+	var inputs []io.ReadCloser
+	defer func() {
+		for _, input := range inputs {
+			input.Close()
+		}
+	}()
+	for _, name := range flags.Args() {
+		input, err := env.Open(name)
+		if err != nil {
+			return err
+		}
+		inputs = append(inputs, input)
+	}
+
+	wr := (*framing).NewWriter(env.Stdout)
+	var (
+		outMu sync.Mutex
+		wg    sync.WaitGroup
+		errMu sync.Mutex
+		first error
+	)
+	for i, input := range inputs {
+		wg.Add(1)
+		go func(name string, input io.Reader) {
+			defer wg.Done()
+			rd := (*framing).NewReader(input)
+			for ctx.Err() == nil {
+				record, err := rd.ReadRecord()
+				if err == nil {
+					outMu.Lock()
+					err = wr.WriteRecord(record)
+					outMu.Unlock()
+				}
+				if err == io.EOF {
+					return
+				} else if err != nil {
+					errMu.Lock()
+					if first == nil {
+						first = err
+					}
+					errMu.Unlock()
+					env.Logf("%s: %v", name, err)
+					return
+				}
+			}
+		}(flags.Arg(i), input)
+	}
+	wg.Wait()
+	return first
+}
@@ -0,0 +1,33 @@
+package builtin
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeSep(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	require.NoError(t, os.WriteFile(a, []byte("1. e4\ne5\n\n2. d4\n\n"), 0o644))
+	require.NoError(t, os.WriteFile(b, []byte("3. c4\nc5"), 0o644))
+
+	var out, stderr bytes.Buffer
+	env := &Env{Name: "merge", Args: []string{"-sep", `\n\n`, a, b}, Stdout: &out, Stderr: &stderr}
+	require.NoError(t, Merge(context.Background(), env))
+	records := strings.SplitAfter(out.String(), "\n\n")
+	assert.Equal(t, "", records[len(records)-1], "the last record is terminated too")
+	records = records[:len(records)-1]
+	sort.Strings(records)
+	assert.Equal(t, []string{"1. e4\ne5\n\n", "2. d4\n\n", "3. c4\nc5\n\n"}, records)
+
+	env.Args = []string{"-sep", "", a}
+	assert.Error(t, Merge(context.Background(), env))
+}
@@ -0,0 +1,33 @@
+package builtin
+
+import (
+	"context"
+	"io"
+
+	"github.com/masp/hoser-runtime/plan"
+)
+
+func init() {
+	Register("tee", Tee, plan.Manifest{
+		In:   []plan.Port{{Name: "stdin", Type: plan.TypeStream}},
+		Out:  []plan.Port{{Name: "stdout", Type: plan.TypeStream}, {Name: "outputs", Type: plan.TypeStream}},
+		Args: []plan.ArgSpec{{Name: "outputs", Variadic: true}},
+	})
+}
+
+// Tee copies stdin to stdout and to every output argument.
+//
+//	builtin:tee output...
+func Tee(ctx context.Context, env *Env) error {
+	writers := []io.Writer{env.Stdout}
+	for _, name := range env.Args {
+		out, err := env.Create(name)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		writers = append(writers, out)
+	}
+	_, err := io.Copy(io.MultiWriter(writers...), env.Stdin)
+	return err
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/masp/hoser-runtime/builtin"
+	"github.com/masp/hoser-runtime/node"
+	"github.com/masp/hoser-runtime/plan"
+	"github.com/masp/hoser-runtime/spill"
+)
+
+// hoser-buffer sits between a fast producer and a bursty consumer. It reads stdin as fast
+// as the producer writes and queues the records for stdout in memory up to -mem bytes,
+// then in a temporary file (see package spill), so the producer never blocks on the
+// 64KB kernel pipe buffer while the consumer is slow. Records are framed according to
+// -framing and -sep and written to stdout with a single write each. How many records are
+// queued is reported with the progress of the node.
+//
+// A plan can get the same behavior without a process of its own by setting "buffer" on a
+// link, which makes the runtime run builtin:buffer on it.
+
+var (
+	n        = node.New("hoser-buffer")
+	framing  = n.FramingFlags()
+	memLimit = n.Flags.Int64("mem", builtin.DefaultBufferSize, "the bytes of records kept in memory before spilling to disk")
+	spillDir = n.Flags.String("spill-dir", "", "directory for the spill file (default: the temporary directory)")
+	stdin    = n.Input("stdin", plan.TypeStream, "the records to buffer")
+	stdout   = n.Output("stdout", plan.TypeStream, "the records, in the same order")
+)
+
+func main() {
+	log.SetOutput(os.Stderr)
+	log.SetFlags(0)
+	n.Run(run)
+}
+
+func run(ctx context.Context) error {
+	in, err := stdin.Open()
+	if err != nil {
+		return err
+	}
+	out, err := stdout.Create()
+	if err != nil {
+		return err
+	}
+	q := spill.New(*memLimit, *spillDir)
+	defer q.Release()
+	return spill.Pump(ctx, (*framing).NewReader(in), (*framing).NewWriter(out), q, n.ProgressQueued)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"container/list"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/masp/hoser-runtime/node"
+)
+
+// A set remembers the keys seen so far, in one of three modes:
+//
+//	exact  every key, so memory grows with the number of distinct keys
+//	lru    the -window most recently seen keys, so a duplicate is only dropped if its key
+//	       was seen recently; good for streams where duplicates arrive close together
+//	bloom  a Bloom filter sized for -capacity keys with a false positive rate of -fp: a
+//	       record is wrongly dropped as a duplicate with that probability, but memory is
+//	       fixed. The rate grows once more than -capacity keys were added.
+type set interface {
+	// add adds the key and reports whether it was already in the set.
+	add(key []byte) bool
+	// state returns what is saved to the state file.
+	state() *state
+}
+
+// isDuplicate reports whether the key of record was seen before, adding it to s. Records without the key field
+// are never duplicates, like they never match in hoser-join.
+func isDuplicate(s set, k node.Key, record []byte) bool {
+	key := k.Extract(record)
+	if key == nil {
+		return false
+	}
+	return s.add([]byte(k.MapKey(key)))
+}
+
+// state is the content of a state file, written with encoding/gob.
+type state struct {
+	Mode  string
+	Keys  []string // exact and lru, for lru from the least to the most recently seen
+	Size  int      // lru: the window; bloom: the capacity
+	FP    float64  // bloom
+	Bits  []uint64 // bloom
+	K     int      // bloom: the number of hashes
+	Count int64    // bloom: the keys added
+}
+
+type exactSet map[string]struct{}
+
+func (s exactSet) add(key []byte) bool {
+	if _, ok := s[string(key)]; ok {
+		return true
+	}
+	s[string(key)] = struct{}{}
+	return false
+}
+
+func (s exactSet) state() *state {
+	st := &state{Mode: "exact", Keys: make([]string, 0, len(s))}
+	for key := range s {
+		st.Keys = append(st.Keys, key)
+	}
+	sort.Strings(st.Keys)
+	return st
+}
+
+type lruSet struct {
+	size  int
+	order *list.List // of string, the front is the most recently seen
+	keys  map[string]*list.Element
+}
+
+func newLRUSet(size int) *lruSet {
+	return &lruSet{size: size, order: list.New(), keys: make(map[string]*list.Element)}
+}
+
+func (s *lruSet) add(key []byte) bool {
+	if e, ok := s.keys[string(key)]; ok {
+		s.order.MoveToFront(e)
+		return true
+	}
+	k := string(key)
+	s.keys[k] = s.order.PushFront(k)
+	if s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.keys, oldest.Value.(string))
+	}
+	return false
+}
+
+func (s *lruSet) state() *state {
+	st := &state{Mode: "lru", Size: s.size, Keys: make([]string, 0, s.order.Len())}
+	for e := s.order.Back(); e != nil; e = e.Prev() {
+		st.Keys = append(st.Keys, e.Value.(string))
+	}
+	return st
+}
+
+type bloomSet struct {
+	capacity int
+	fp       float64
+	bits     []uint64
+	k        int
+	count    int64
+	warned   bool
+}
+
+// newBloomSet sizes a filter for capacity keys at a false positive rate of fp.
+func newBloomSet(capacity int, fp float64) *bloomSet {
+	m := math.Ceil(-float64(capacity) * math.Log(fp) / (math.Ln2 * math.Ln2))
+	k := int(math.Round(m / float64(capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomSet{capacity: capacity, fp: fp, bits: make([]uint64, (int(m)+63)/64), k: k}
+}
+
+func (s *bloomSet) add(key []byte) bool {
+	// Double hashing: the k hashes are h1 + i*h2, both derived from one FNV hash of the key
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	h1, h2 := mix(sum), mix(sum^0x9e3779b97f4a7c15)|1
+	m := uint64(len(s.bits)) * 64
+	seen := true
+	for i := 0; i < s.k; i++ {
+		bit := (h1 + uint64(i)*h2) % m
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if s.bits[word]&mask == 0 {
+			seen = false
+			s.bits[word] |= mask
+		}
+	}
+	if !seen {
+		s.count++
+		if s.count > int64(s.capacity) && !s.warned {
+			s.warned = true
+			n.Logf("bloom filter is over its capacity of %d keys, the false positive rate grows from now on", s.capacity)
+		}
+	}
+	return seen
+}
+
+// mix is the finalizer of splitmix64. FNV alone spreads short keys that differ in one byte badly over the bits.
+func mix(x uint64) uint64 {
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+func (s *bloomSet) state() *state {
+	return &state{Mode: "bloom", Size: s.capacity, FP: s.fp, Bits: s.bits, K: s.k, Count: s.count}
+}
+
+// newSet creates an empty set for the mode.
+func newSet(mode string, window, capacity int, fp float64) (set, error) {
+	switch mode {
+	case "exact":
+		return exactSet{}, nil
+	case "lru":
+		if window < 1 {
+			return nil, fmt.Errorf("-window must be at least 1")
+		}
+		return newLRUSet(window), nil
+	case "bloom":
+		if capacity < 1 || fp <= 0 || fp >= 1 {
+			return nil, fmt.Errorf("-capacity must be at least 1 and -fp between 0 and 1")
+		}
+		return newBloomSet(capacity, fp), nil
+	default:
+		return nil, fmt.Errorf("unknown mode '%s'", mode)
+	}
+}
+
+// restore recreates the set saved in a state file. The sizes of the file win over the flags, so that a filter is
+// never resized under its keys.
+func restore(st *state) (set, error) {
+	switch st.Mode {
+	case "exact":
+		s := make(exactSet, len(st.Keys))
+		for _, key := range st.Keys {
+			s[key] = struct{}{}
+		}
+		return s, nil
+	case "lru":
+		s := newLRUSet(st.Size)
+		for _, key := range st.Keys {
+			s.add([]byte(key))
+		}
+		return s, nil
+	case "bloom":
+		if len(st.Bits) == 0 || st.K < 1 {
+			return nil, fmt.Errorf("invalid bloom filter")
+		}
+		return &bloomSet{capacity: st.Size, fp: st.FP, bits: st.Bits, k: st.K, count: st.Count}, nil
+	default:
+		return nil, fmt.Errorf("unknown mode '%s'", st.Mode)
+	}
+}
+
+// load reads a state file. It returns nil and no error if the file does not exist.
+func load(path string) (*state, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st := new(state)
+	if err := gob.NewDecoder(f).Decode(st); err != nil {
+		return nil, fmt.Errorf("read state %s: %w", path, err)
+	}
+	return st, nil
+}
+
+// save replaces the state file at once, so that a crash while saving leaves the previous state.
+func save(path string, s set) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := gob.NewEncoder(tmp).Encode(s.state()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/masp/hoser-runtime/node"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func addAll(s set, keys ...string) []bool {
+	var dups []bool
+	for _, key := range keys {
+		dups = append(dups, s.add([]byte(key)))
+	}
+	return dups
+}
+
+func TestSets(t *testing.T) {
+	exact, err := newSet("exact", 0, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, false, true, false, true}, addAll(exact, "a", "b", "a", "c", "b"))
+
+	lru, err := newSet("lru", 2, 0, 0)
+	require.NoError(t, err)
+	// b is seen again and stays in the window, c pushes a out
+	assert.Equal(t, []bool{false, false, true, false, false, true}, addAll(lru, "a", "b", "b", "c", "a", "c"))
+
+	bloom, err := newSet("bloom", 0, 1000, 0.01)
+	require.NoError(t, err)
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		if bloom.add([]byte(fmt.Sprint(i))) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, falsePositives, 30)
+	for i := 0; i < 1000; i++ {
+		assert.True(t, bloom.add([]byte(fmt.Sprint(i))))
+	}
+
+	_, err = newSet("lru", 0, 0, 0)
+	assert.Error(t, err)
+	_, err = newSet("bloom", 0, 10, 1.5)
+	assert.Error(t, err)
+	_, err = newSet("fuzzy", 0, 0, 0)
+	assert.Error(t, err)
+}
+
+func TestIsDuplicate(t *testing.T) {
+	s, err := newSet("exact", 0, 0, 0)
+	require.NoError(t, err)
+	key := node.Key{Field: 2, Sep: ",", Numeric: true}
+	var kept []string
+	for _, record := range []string{"a,1", "x", "y", "b,1.0", "c,2", "z"} {
+		if !isDuplicate(s, key, []byte(record)) {
+			kept = append(kept, record)
+		}
+	}
+	assert.Equal(t, []string{"a,1", "x", "y", "c,2", "z"}, kept)
+}
+
+func TestState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	st, err := load(path)
+	require.NoError(t, err)
+	assert.Nil(t, st)
+
+	for _, mode := range []string{"exact", "lru", "bloom"} {
+		s, err := newSet(mode, 2, 100, 0.01)
+		require.NoError(t, err)
+		addAll(s, "a", "b", "c")
+		require.NoError(t, save(path, s))
+
+		st, err := load(path)
+		require.NoError(t, err)
+		restored, err := restore(st)
+		require.NoError(t, err, mode)
+		assert.Equal(t, s.state(), restored.state(), mode)
+		assert.True(t, restored.add([]byte("c")), mode)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/masp/hoser-runtime/node"
+	"github.com/masp/hoser-runtime/plan"
+)
+
+// hoser-dedupe copies the records of stdin to stdout, dropping every record whose key
+// (see node.KeyFlags, the whole record by default) was seen before. Records without the
+// key field are always copied. How seen keys are remembered is set with -mode (see
+// dedupe.go).
+//
+// With -state, the seen keys are loaded from the file at start and saved to it at exit
+// and every -checkpoint, so that a restarted pipe does not repeat records. The mode and
+// sizes stored in an existing file are used instead of the flags. Records written after
+// the last save are not remembered if hoser-dedupe is killed.
+
+var (
+	n          = node.New("hoser-dedupe")
+	framing    = n.FramingFlags()
+	key        = n.KeyFlags()
+	mode       = n.Flags.String("mode", "exact", "how seen keys are remembered: exact, lru or bloom")
+	window     = n.Flags.Int("window", 100000, "with -mode lru, the number of recently seen keys remembered")
+	capacity   = n.Flags.Int("capacity", 10000000, "with -mode bloom, the number of keys the filter is sized for")
+	fp         = n.Flags.Float64("fp", 0.001, "with -mode bloom, the false positive rate, i.e. how often a new key is taken for a duplicate")
+	statePath  = n.Flags.String("state", "", "load the seen keys from this file and save them to it")
+	checkpoint = n.Flags.Duration("checkpoint", time.Minute, "with -state, how often the seen keys are saved (0 saves only at exit)")
+	stdin      = n.Input("stdin", plan.TypeStream, "the records")
+	stdout     = n.Output("stdout", plan.TypeStream, "the records without duplicates")
+)
+
+func main() {
+	log.SetOutput(os.Stderr)
+	log.SetFlags(0)
+	n.Run(run)
+}
+
+func run(ctx context.Context) error {
+	seen, err := newSet(*mode, *window, *capacity, *fp)
+	if err != nil {
+		return err
+	}
+	if *statePath != "" {
+		st, err := load(*statePath)
+		if err != nil {
+			return err
+		}
+		if st != nil {
+			if seen, err = restore(st); err != nil {
+				return fmt.Errorf("state %s: %w", *statePath, err)
+			}
+			modeSet := false
+			n.Flags.Visit(func(f *flag.Flag) { modeSet = modeSet || f.Name == "mode" })
+			if modeSet && st.Mode != *mode {
+				n.Logf("using mode %s of the state file instead of %s", st.Mode, *mode)
+			}
+		}
+	}
+
+	in, err := stdin.Open()
+	if err != nil {
+		return err
+	}
+	out, err := stdout.Create()
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	rd := (*framing).NewReader(in)
+	wr := (*framing).NewWriter(out)
+	var records, totalBytes, dropped int64
+	lastSave := time.Now()
+	err = func() error {
+		for ctx.Err() == nil {
+			record, err := rd.ReadRecord()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("read stdin: %w", err)
+			}
+			if isDuplicate(seen, *key, record) {
+				dropped++
+				continue
+			}
+			if err := wr.WriteRecord(record); err != nil {
+				return fmt.Errorf("write stdout: %w", err)
+			}
+			records++
+			totalBytes += int64(len(record))
+			n.Progress(records, totalBytes)
+
+			if *statePath != "" && *checkpoint > 0 && time.Since(lastSave) >= *checkpoint {
+				if err := save(*statePath, seen); err != nil {
+					n.Errorf("save state: %v", err)
+				}
+				lastSave = time.Now()
+			}
+		}
+		return nil
+	}()
+	if dropped > 0 {
+		n.Logf("dropped %d duplicates", dropped)
+	}
+	if *statePath != "" {
+		if serr := save(*statePath, seen); serr != nil && err == nil {
+			err = fmt.Errorf("save state: %w", serr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/masp/hoser-runtime/node"
+)
+
+// Two strategies join the records of the left and right inputs whose keys are equal:
+//
+//	hash   the right input is read into memory first, then the left input is streamed
+//	       against it. Neither input has to be sorted. Joined records follow the order of
+//	       the left input, unmatched right records of an outer join come last.
+//	merge  both inputs are streamed at once and must be sorted by their key in ascending
+//	       order (like hoser-sort without -r). Only the right records of one key are kept
+//	       in memory at a time. An input that is not sorted is an error.
+//
+// Records without the key field never match, but are kept by left and outer joins.
+
+// joiner joins two inputs and passes every pair to emit. The side that is missing in a left or outer join is nil.
+type joiner struct {
+	leftKey, rightKey   node.Key
+	keepLeft, keepRight bool // Emit unmatched records of the side
+	emit                func(left, right []byte) error
+}
+
+// newJoiner creates a joiner for an inner, left or outer join.
+func newJoiner(kind string, leftKey, rightKey node.Key, emit func(left, right []byte) error) (*joiner, error) {
+	j := &joiner{leftKey: leftKey, rightKey: rightKey, emit: emit}
+	switch kind {
+	case "inner":
+	case "left":
+		j.keepLeft = true
+	case "outer":
+		j.keepLeft, j.keepRight = true, true
+	default:
+		return nil, fmt.Errorf("unknown join type '%s'", kind)
+	}
+	return j, nil
+}
+
+func (j *joiner) hash(ctx context.Context, left, right node.RecordReader) error {
+	var (
+		rights  [][]byte
+		matched []bool
+		table   = make(map[string][]int)
+	)
+	for ctx.Err() == nil {
+		record, err := right.ReadRecord()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("read right: %w", err)
+		}
+		record = append([]byte(nil), record...)
+		if key := j.rightKey.Extract(record); key != nil {
+			k := j.rightKey.MapKey(key)
+			table[k] = append(table[k], len(rights))
+		}
+		rights = append(rights, record)
+		matched = append(matched, false)
+	}
+
+	for ctx.Err() == nil {
+		record, err := left.ReadRecord()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("read left: %w", err)
+		}
+		var matches []int
+		if key := j.leftKey.Extract(record); key != nil {
+			matches = table[j.leftKey.MapKey(key)]
+		}
+		if len(matches) == 0 && j.keepLeft {
+			if err := j.emit(record, nil); err != nil {
+				return err
+			}
+		}
+		for _, i := range matches {
+			matched[i] = true
+			if err := j.emit(record, rights[i]); err != nil {
+				return err
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if j.keepRight {
+		for i, record := range rights {
+			if !matched[i] {
+				if err := j.emit(nil, record); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// sortedInput reads an input of a merge join, checking that it is sorted.
+type sortedInput struct {
+	name    string
+	rd      node.RecordReader
+	key     node.Key
+	record  []byte
+	k       []byte // The key of record, nil if it has none
+	last    []byte // The last key that was not nil
+	eof     bool
+	records int64
+}
+
+func (in *sortedInput) next() error {
+	record, err := in.rd.ReadRecord()
+	if err == io.EOF {
+		in.eof, in.record, in.k = true, nil, nil
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("read %s: %w", in.name, err)
+	}
+	in.records++
+	in.record = append([]byte(nil), record...)
+	in.k = in.key.Extract(in.record)
+	if in.k == nil {
+		return nil
+	}
+	if in.last != nil && in.key.CompareKeys(in.k, in.last) < 0 {
+		return fmt.Errorf("%s is not sorted by %s: record %d has key '%s' after '%s'", in.name, in.key, in.records, in.k, in.last)
+	}
+	in.last = in.k
+	return nil
+}
+
+func (j *joiner) merge(ctx context.Context, left, right node.RecordReader) error {
+	l := &sortedInput{name: "left", rd: left, key: j.leftKey}
+	r := &sortedInput{name: "right", rd: right, key: j.rightKey}
+	if err := l.next(); err != nil {
+		return err
+	}
+	if err := r.next(); err != nil {
+		return err
+	}
+	var group [][]byte
+	for ctx.Err() == nil {
+		switch {
+		case l.eof && r.eof:
+			return nil
+		case !l.eof && (l.k == nil || r.eof):
+			if j.keepLeft {
+				if err := j.emit(l.record, nil); err != nil {
+					return err
+				}
+			}
+			if err := l.next(); err != nil {
+				return err
+			}
+		case !r.eof && (r.k == nil || l.eof):
+			if j.keepRight {
+				if err := j.emit(nil, r.record); err != nil {
+					return err
+				}
+			}
+			if err := r.next(); err != nil {
+				return err
+			}
+		default:
+			c := j.leftKey.CompareKeys(l.k, r.k)
+			if c < 0 {
+				if j.keepLeft {
+					if err := j.emit(l.record, nil); err != nil {
+						return err
+					}
+				}
+				if err := l.next(); err != nil {
+					return err
+				}
+				continue
+			}
+			if c > 0 {
+				if j.keepRight {
+					if err := j.emit(nil, r.record); err != nil {
+						return err
+					}
+				}
+				if err := r.next(); err != nil {
+					return err
+				}
+				continue
+			}
+			// Every right record of the key is matched by at least the current left record
+			key := r.k
+			group = group[:0]
+			for !r.eof && r.k != nil && j.rightKey.CompareKeys(r.k, key) == 0 {
+				group = append(group, r.record)
+				if err := r.next(); err != nil {
+					return err
+				}
+			}
+			for !l.eof && l.k != nil && j.leftKey.CompareKeys(l.k, key) == 0 {
+				for _, right := range group {
+					if err := j.emit(l.record, right); err != nil {
+						return err
+					}
+				}
+				if err := l.next(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return ctx.Err()
+}
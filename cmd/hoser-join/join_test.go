@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/masp/hoser-runtime/node"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func joinAll(t *testing.T, strategy, kind string, key node.Key, left, right string) ([]string, error) {
+	var got []string
+	j, err := newJoiner(kind, key, key, func(l, r []byte) error {
+		got = append(got, string(l)+"|"+string(r))
+		return nil
+	})
+	require.NoError(t, err)
+	lrd := node.Delim("\n").NewReader(strings.NewReader(left))
+	rrd := node.Delim("\n").NewReader(strings.NewReader(right))
+	if strategy == "hash" {
+		err = j.hash(context.Background(), lrd, rrd)
+	} else {
+		err = j.merge(context.Background(), lrd, rrd)
+	}
+	return got, err
+}
+
+func TestJoin(t *testing.T) {
+	key := node.Key{Field: 1, Sep: ","}
+	left := "a,1\nb,2\nb,3\nd,4\n"
+	right := "a,x\nb,y\nb,w\nc,z\n"
+	inner := []string{"a,1|a,x", "b,2|b,y", "b,2|b,w", "b,3|b,y", "b,3|b,w"}
+	for _, strategy := range []string{"hash", "merge"} {
+		got, err := joinAll(t, strategy, "inner", key, left, right)
+		require.NoError(t, err)
+		assert.Equal(t, inner, got, strategy)
+
+		got, err = joinAll(t, strategy, "left", key, left, right)
+		require.NoError(t, err)
+		assert.Equal(t, append(inner, "d,4|"), got, strategy)
+
+		got, err = joinAll(t, strategy, "outer", key, left, right)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, append(inner, "d,4|", "|c,z"), got, strategy)
+	}
+
+	// Numeric keys that are equal as numbers match with both strategies
+	numeric := node.Key{Field: 1, Sep: ",", Numeric: true}
+	for _, strategy := range []string{"hash", "merge"} {
+		got, err := joinAll(t, strategy, "inner", numeric, "1,a\n2.0,b\n", "1.0,x\n2,y\n10,z\n")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"1,a|1.0,x", "2.0,b|2,y"}, got, strategy)
+	}
+
+	// Records without the key never match
+	got, err := joinAll(t, "hash", "outer", node.Key{Field: 2, Sep: ","}, "a\n", "b\n")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a|", "|b"}, got)
+
+	_, err = joinAll(t, "merge", "inner", key, "b,1\na,2\n", "a,x\n")
+	assert.ErrorContains(t, err, "left is not sorted")
+	_, err = newJoiner("cross", key, key, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/masp/hoser-runtime/node"
+	"github.com/masp/hoser-runtime/plan"
+)
+
+// hoser-join joins the records of its left and right inputs that have the same key (see
+// node.KeyFlags) and writes every joined pair to stdout, e.g. to enrich events with a
+// table of users. -right-key selects a different field of the right records.
+//
+// -type is inner (only pairs), left (also the left records without a match) or outer
+// (also the right records without a match). -strategy is hash or merge (see join.go);
+// with hash the left input is only read once the right input ended.
+//
+// With -format concat a joined record is the left record, -ofs and the right record, a
+// missing side is written as -empty. With -format json it is {"left": ..., "right": ...}
+// with null for a missing side, the records embedded as is with -framing jsonl and as
+// strings otherwise.
+
+var (
+	n        = node.New("hoser-join")
+	framing  = n.FramingFlags()
+	key      = n.KeyFlags()
+	rightKey = n.Flags.Int("right-key", -1, "the key field of the right records (default: the same as -key)")
+	kind     = n.Flags.String("type", "inner", "the join: inner, left or outer")
+	strategy = n.Flags.String("strategy", "hash", "hash (the right input is kept in memory) or merge (both inputs are sorted by key)")
+	format   = n.Flags.String("format", "concat", "how joined records are written: concat or json")
+	ofs      = n.Flags.String("ofs", "", "with -format concat, the separator between the left and right record (default: -fs, or a tab)")
+	empty    = n.Flags.String("empty", "", "with -format concat, what is written for the missing side of a left or outer join")
+	left     = n.Input("left", plan.TypeStream, "the left records")
+	right    = n.Input("right", plan.TypeStream, "the right records")
+	stdout   = n.Output("stdout", plan.TypeStream, "the joined records")
+)
+
+func main() {
+	log.SetOutput(os.Stderr)
+	log.SetFlags(0)
+	n.Run(run)
+}
+
+func run(ctx context.Context) error {
+	rkey := *key
+	if *rightKey >= 0 {
+		rkey.Field = *rightKey
+	}
+	if *format != "concat" && *format != "json" {
+		return fmt.Errorf("unknown format '%s'", *format)
+	}
+	sep := *ofs
+	if sep == "" {
+		sep = key.Sep
+	}
+	if sep == "" {
+		sep = "\t"
+	}
+
+	lin, err := left.Open()
+	if err != nil {
+		return err
+	}
+	defer lin.Close()
+	rin, err := right.Open()
+	if err != nil {
+		return err
+	}
+	defer rin.Close()
+	out, err := stdout.Create()
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	wr := (*framing).NewWriter(out)
+	_, raw := (*framing).(node.JSONLines)
+	var records, totalBytes int64
+	var buf []byte
+	emit := func(l, r []byte) error {
+		if *format == "json" {
+			var err error
+			if buf, err = json.Marshal(map[string]any{"left": jsonValue(l, raw), "right": jsonValue(r, raw)}); err != nil {
+				return err
+			}
+		} else {
+			buf = append(append(append(buf[:0], orEmpty(l)...), sep...), orEmpty(r)...)
+		}
+		if err := wr.WriteRecord(buf); err != nil {
+			return fmt.Errorf("write stdout: %w", err)
+		}
+		records++
+		totalBytes += int64(len(buf))
+		n.Progress(records, totalBytes)
+		return nil
+	}
+
+	j, err := newJoiner(*kind, *key, rkey, emit)
+	if err != nil {
+		return err
+	}
+	lrd, rrd := (*framing).NewReader(lin), (*framing).NewReader(rin)
+	switch *strategy {
+	case "hash":
+		return j.hash(ctx, lrd, rrd)
+	case "merge":
+		return j.merge(ctx, lrd, rrd)
+	default:
+		return fmt.Errorf("unknown strategy '%s'", *strategy)
+	}
+}
+
+func orEmpty(record []byte) []byte {
+	if record == nil {
+		return []byte(*empty)
+	}
+	return record
+}
+
+func jsonValue(record []byte, raw bool) any {
+	switch {
+	case record == nil:
+		return nil
+	case raw:
+		return json.RawMessage(record)
+	default:
+		return string(record)
+	}
+}
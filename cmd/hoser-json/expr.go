@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Filters (-filter) are expressions over the fields of a record:
+//
+//	.status == 200 && .method != "GET"
+//	.latency > 1.5 || !.ok
+//	.path =~ "^/api/" and not .user.admin
+//
+// Values are paths (see path.go), strings in double or single quotes, numbers, true, false and null. The
+// operators, from lowest to highest precedence, are || (or), && (and), ! (not) and the comparisons == != < <= > >=
+// =~ !~ (regex match). Parentheses group. A value on its own is true unless it is missing, null or false.
+// Ordering compares numbers with numbers and strings with strings, anything else is false.
+
+type expr interface {
+	eval(doc any) any
+}
+
+type literal struct{ value any }
+
+type pathExpr struct{ path path }
+
+type notExpr struct{ e expr }
+
+type logicExpr struct {
+	and  bool
+	l, r expr
+}
+
+type compareExpr struct {
+	op   string
+	l, r expr
+}
+
+type matchExpr struct {
+	l      expr
+	re     *regexp.Regexp
+	negate bool
+}
+
+func (e literal) eval(any) any { return e.value }
+
+func (e pathExpr) eval(doc any) any {
+	v, _ := e.path.get(doc)
+	if num, ok := v.(json.Number); ok { // records are decoded with UseNumber to keep them exact in the output
+		f, err := num.Float64()
+		if err != nil {
+			return num.String()
+		}
+		return f
+	}
+	return v
+}
+
+func (e notExpr) eval(doc any) any { return !truthy(e.e.eval(doc)) }
+
+func (e logicExpr) eval(doc any) any {
+	l := truthy(e.l.eval(doc))
+	if e.and != l { // false && ..., true || ...
+		return l
+	}
+	return truthy(e.r.eval(doc))
+}
+
+func (e compareExpr) eval(doc any) any {
+	l, r := e.l.eval(doc), e.r.eval(doc)
+	switch e.op {
+	case "==":
+		return equal(l, r)
+	case "!=":
+		return !equal(l, r)
+	}
+	c, ok := compare(l, r)
+	if !ok {
+		return false
+	}
+	switch e.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default: // ">="
+		return c >= 0
+	}
+}
+
+func (e matchExpr) eval(doc any) any {
+	var s string
+	switch v := e.l.eval(doc).(type) {
+	case string:
+		s = v
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		s = strconv.FormatBool(v)
+	default:
+		return false
+	}
+	return e.re.MatchString(s) != e.negate
+}
+
+func truthy(v any) bool {
+	return v != nil && v != false
+}
+
+func equal(a, b any) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func compare(a, b any) (int, bool) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			switch {
+			case a < b:
+				return -1, true
+			case a > b:
+				return 1, true
+			}
+			return 0, true
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	}
+	return 0, false
+}
+
+// parseExpr parses a filter expression.
+func parseExpr(s string) (expr, error) {
+	p := &parser{lex: lexer{src: s}}
+	p.next()
+	e, err := p.or()
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	if p.tok.kind != tokEOF {
+		return nil, fmt.Errorf("invalid filter: unexpected '%s' at %d", p.tok.text, p.tok.pos)
+	}
+	return e, nil
+}
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokPath
+	tokString
+	tokNumber
+	tokIdent
+	tokOp
+	tokErr
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+var operators = []string{"||", "&&", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "(", ")"}
+
+func (l *lexer) next() token {
+	for l.pos < len(l.src) && strings.ContainsRune(" \t\n\r", rune(l.src[l.pos])) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}
+	}
+	c := l.src[l.pos]
+	switch {
+	case c == '.':
+		l.pos++
+		for l.pos < len(l.src) {
+			c := l.src[l.pos]
+			if c == '[' {
+				end := strings.IndexByte(l.src[l.pos:], ']')
+				if end < 0 {
+					break
+				}
+				l.pos += end + 1
+			} else if c == '.' || isNameChar(c) {
+				l.pos++
+			} else {
+				break
+			}
+		}
+		return token{kind: tokPath, text: l.src[start:l.pos], pos: start}
+	case c == '"' || c == '\'':
+		l.pos++
+		for l.pos < len(l.src) && l.src[l.pos] != c {
+			if l.src[l.pos] == '\\' {
+				l.pos++
+			}
+			l.pos++
+		}
+		if l.pos >= len(l.src) {
+			return token{kind: tokErr, text: "unterminated string", pos: start}
+		}
+		l.pos++
+		return token{kind: tokString, text: l.src[start:l.pos], pos: start}
+	case c == '-' || c >= '0' && c <= '9':
+		l.pos++
+		for l.pos < len(l.src) && strings.ContainsRune("0123456789.eE+-", rune(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokNumber, text: l.src[start:l.pos], pos: start}
+	case isNameChar(c):
+		for l.pos < len(l.src) && isNameChar(l.src[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokIdent, text: l.src[start:l.pos], pos: start}
+	}
+	for _, op := range operators {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			return token{kind: tokOp, text: op, pos: start}
+		}
+	}
+	return token{kind: tokErr, text: string(c), pos: start}
+}
+
+type parser struct {
+	lex lexer
+	tok token
+}
+
+func (p *parser) next() {
+	p.tok = p.lex.next()
+}
+
+// is reports if the current token is the operator op or, for the logical operators, its keyword.
+func (p *parser) is(op, keyword string) bool {
+	return p.tok.kind == tokOp && p.tok.text == op || keyword != "" && p.tok.kind == tokIdent && p.tok.text == keyword
+}
+
+func (p *parser) or() (expr, error) {
+	l, err := p.and()
+	for err == nil && p.is("||", "or") {
+		p.next()
+		var r expr
+		r, err = p.and()
+		l = logicExpr{and: false, l: l, r: r}
+	}
+	return l, err
+}
+
+func (p *parser) and() (expr, error) {
+	l, err := p.not()
+	for err == nil && p.is("&&", "and") {
+		p.next()
+		var r expr
+		r, err = p.not()
+		l = logicExpr{and: true, l: l, r: r}
+	}
+	return l, err
+}
+
+func (p *parser) not() (expr, error) {
+	if p.is("!", "not") {
+		p.next()
+		e, err := p.not()
+		return notExpr{e}, err
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (expr, error) {
+	l, err := p.value()
+	if err != nil || p.tok.kind != tokOp {
+		return l, err
+	}
+	op := p.tok.text
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.next()
+		r, err := p.value()
+		return compareExpr{op: op, l: l, r: r}, err
+	case "=~", "!~":
+		p.next()
+		if p.tok.kind != tokString {
+			return nil, fmt.Errorf("%s needs a string pattern at %d", op, p.tok.pos)
+		}
+		pattern, err := unquote(p.tok.text)
+		if err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		p.next()
+		return matchExpr{l: l, re: re, negate: op == "!~"}, nil
+	}
+	return l, nil
+}
+
+func (p *parser) value() (expr, error) {
+	tok := p.tok
+	p.next()
+	switch tok.kind {
+	case tokPath:
+		path, err := parsePath(tok.text)
+		return pathExpr{path}, err
+	case tokString:
+		s, err := unquote(tok.text)
+		return literal{s}, err
+	case tokNumber:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s' at %d", tok.text, tok.pos)
+		}
+		return literal{f}, nil
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		case "null":
+			return literal{nil}, nil
+		}
+		return nil, fmt.Errorf("unknown name '%s' at %d, paths start with '.'", tok.text, tok.pos)
+	case tokOp:
+		if tok.text == "(" {
+			e, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if !p.is(")", "") {
+				return nil, fmt.Errorf("missing ')' at %d", p.tok.pos)
+			}
+			p.next()
+			return e, nil
+		}
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end")
+	case tokErr:
+		return nil, fmt.Errorf("%s at %d", tok.text, tok.pos)
+	}
+	return nil, fmt.Errorf("unexpected '%s' at %d", tok.text, tok.pos)
+}
+
+// unquote interprets a string token. Single quoted strings are like double quoted ones, except that " needs no
+// escaping.
+func unquote(text string) (string, error) {
+	if text[0] == '\'' {
+		inner := text[1 : len(text)-1]
+		inner = strings.ReplaceAll(inner, `\'`, `'`)
+		inner = strings.ReplaceAll(inner, `"`, `\"`)
+		text = `"` + inner + `"`
+	}
+	var s string
+	if err := json.Unmarshal([]byte(text), &s); err != nil {
+		return "", fmt.Errorf("invalid string %s: %v", text, err)
+	}
+	return s, nil
+}
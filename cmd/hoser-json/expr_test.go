@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decode(t *testing.T, s string) any {
+	dec := json.NewDecoder(bytes.NewReader([]byte(s)))
+	dec.UseNumber()
+	var doc any
+	require.NoError(t, dec.Decode(&doc))
+	return doc
+}
+
+func TestPath(t *testing.T) {
+	doc := decode(t, `{"a": {"b": [1, {"c": "x"}]}, "some key": true}`)
+	for text, want := range map[string]any{
+		".":               doc,
+		"a.b[0]":          json.Number("1"),
+		".a.b[1].c":       "x",
+		".a.b[-1].c":      "x",
+		`["some key"]`:    true,
+		`.a.["b"][0]`:     json.Number("1"),
+		".a.missing":      nil,
+		".a.b[2]":         nil,
+		".a.b.c":          nil,
+		`.["some key"].x`: nil,
+	} {
+		p, err := parsePath(text)
+		require.NoError(t, err, text)
+		got, ok := p.get(doc)
+		assert.Equal(t, want, got, text)
+		assert.Equal(t, want != nil, ok, text)
+	}
+	for _, text := range []string{"a..b", "a[x]", "a[0", `a["b`} {
+		_, err := parsePath(text)
+		assert.Error(t, err, text)
+	}
+
+	flat := make(map[string]any)
+	flatten("", doc, flat)
+	assert.Equal(t, map[string]any{"a.b[0]": json.Number("1"), "a.b[1].c": "x", "some key": true}, flat)
+}
+
+func TestExpr(t *testing.T) {
+	doc := decode(t, `{"status": 503, "method": "GET", "path": "/api/v1", "ok": false, "user": {"admin": true}, "tags": ["a"]}`)
+	for s, want := range map[string]bool{
+		".status == 503":                        true,
+		".status >= 500 && .method != 'GET'":    false,
+		".status >= 500 and .method == \"GET\"": true,
+		".ok || .user.admin":                    true,
+		"!.ok":                                  true,
+		"not .user.admin":                       false,
+		".missing":                              false,
+		".missing == null":                      true,
+		".path =~ '^/api/'":                     true,
+		".path !~ '^/api/'":                     false,
+		".status =~ '^5'":                       true,
+		".method < 'POST'":                      true,
+		".method < 1":                           false,
+		".tags == .tags":                        true,
+		"(.ok || .status > 1) && !(.ok)":        true,
+		"-1 < .status":                          true,
+	} {
+		e, err := parseExpr(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, want, truthy(e.eval(doc)), s)
+	}
+	for _, s := range []string{"", ".a ==", "a == 1", "(.a", ".a =~ .b", ".a =~ '('", ".a == 'x", ".a ; .b"} {
+		_, err := parseExpr(s)
+		assert.Error(t, err, s)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/masp/hoser-runtime/node"
+	"github.com/masp/hoser-runtime/plan"
+)
+
+// hoser-json works on records that are JSON values, one per record (use -framing jsonl
+// for JSON lines that may span several lines). For every record, in order:
+//
+//	-filter expr   drops the record unless expr is true (see expr.go)
+//	-get path      writes only the value at path, strings without quotes
+//	-select paths  projects the record to an object of the comma separated paths
+//	-flatten       turns nested objects and arrays into dotted keys, e.g. "a.b[0]"
+//
+// With -format tsv or csv every record is written as a row. The columns are the -select
+// paths or, with -flatten, the keys of the first record. Missing values and null are
+// empty cells, other values that are not strings are written as JSON.
+//
+// Records that are not valid JSON are reported and skipped. Output records are framed
+// like the input, except that values that are not JSON are written as lines when the
+// input is -framing jsonl.
+
+var (
+	n          = node.New("hoser-json")
+	framing    = n.FramingFlags()
+	filterFlag = n.Flags.String("filter", "", "only keep the records for which this expression is true, e.g. '.status >= 500'")
+	getFlag    = n.Flags.String("get", "", "write the value at this path instead of the record")
+	selectFlag = n.Flags.String("select", "", "write an object of these comma separated paths instead of the record")
+	flattenArg = n.Flags.Bool("flatten", false, "flatten nested objects and arrays into dotted keys")
+	format     = n.Flags.String("format", "json", "the output format: json, tsv or csv")
+	header     = n.Flags.Bool("header", false, "with -format tsv or csv, write the column names first")
+	stdin      = n.Input("stdin", plan.TypeStream, "the JSON records")
+	stdout     = n.Output("stdout", plan.TypeStream, "the filtered and transformed records")
+)
+
+func main() {
+	log.SetOutput(os.Stderr)
+	log.SetFlags(0)
+	n.Run(run)
+}
+
+func run(ctx context.Context) error {
+	t, err := newTransform()
+	if err != nil {
+		return err
+	}
+	in, err := stdin.Open()
+	if err != nil {
+		return err
+	}
+	out, err := stdout.Create()
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	outFraming := *framing
+	if _, ok := outFraming.(node.JSONLines); ok && (t.get != nil || t.format != "json") {
+		outFraming = node.Delim("\n")
+	}
+	rd := (*framing).NewReader(in)
+	wr := outFraming.NewWriter(out)
+	var records, totalBytes, invalid int64
+	for ctx.Err() == nil {
+		record, err := rd.ReadRecord()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("read stdin: %w", err)
+		}
+		outs, err := t.apply(record)
+		if err != nil {
+			if invalid == 0 {
+				n.Errorf("skipping invalid record: %v", err)
+			}
+			invalid++
+			continue
+		}
+		for _, rec := range outs {
+			if err := wr.WriteRecord(rec); err != nil {
+				return err
+			}
+			records++
+			totalBytes += int64(len(rec))
+		}
+		n.Progress(records, totalBytes)
+	}
+	if invalid > 1 {
+		n.Logf("skipped %d invalid records", invalid)
+	}
+	return nil
+}
+
+// transform is what the flags do to every record.
+type transform struct {
+	filter  expr
+	get     *path
+	selects []path
+	flatten bool
+	format  string
+	header  bool
+	columns []string // For tsv and csv, the -select paths or the keys of the first flattened record
+	started bool
+}
+
+func newTransform() (*transform, error) {
+	t := &transform{flatten: *flattenArg, format: *format, header: *header}
+	var err error
+	if *filterFlag != "" {
+		if t.filter, err = parseExpr(*filterFlag); err != nil {
+			return nil, err
+		}
+	}
+	if *getFlag != "" {
+		p, err := parsePath(*getFlag)
+		if err != nil {
+			return nil, err
+		}
+		t.get = &p
+	}
+	if *selectFlag != "" {
+		if t.selects, err = parsePaths(*selectFlag); err != nil {
+			return nil, err
+		}
+		for _, p := range t.selects {
+			t.columns = append(t.columns, p.name())
+		}
+	}
+	switch t.format {
+	case "json":
+	case "tsv", "csv":
+		if t.get != nil {
+			return nil, fmt.Errorf("-get cannot be used with -format %s", t.format)
+		}
+		if t.selects == nil && !t.flatten {
+			return nil, fmt.Errorf("-format %s needs -select or -flatten for its columns", t.format)
+		}
+	default:
+		return nil, fmt.Errorf("unknown format '%s'", t.format)
+	}
+	if t.get != nil && (t.selects != nil || t.flatten) {
+		return nil, fmt.Errorf("-get cannot be used with -select or -flatten")
+	}
+	return t, nil
+}
+
+// apply returns the output records for a record: none if it is filtered out, two for the first row with -header.
+func (t *transform) apply(record []byte) ([][]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(record))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("more than one JSON value in a record")
+	}
+	if t.filter != nil && !truthy(t.filter.eval(doc)) {
+		return nil, nil
+	}
+
+	if t.get != nil {
+		v, _ := t.get.get(doc)
+		if s, ok := v.(string); ok {
+			return [][]byte{[]byte(s)}, nil
+		}
+		data, err := json.Marshal(v)
+		return [][]byte{data}, err
+	}
+	if t.selects == nil && !t.flatten && t.format == "json" {
+		return [][]byte{record}, nil // the record as it was
+	}
+	if t.selects != nil {
+		obj := make(map[string]any, len(t.selects))
+		for _, p := range t.selects {
+			obj[p.name()], _ = p.get(doc)
+		}
+		doc = obj
+	}
+	if t.flatten {
+		flat := make(map[string]any)
+		flatten("", doc, flat)
+		doc = flat
+		if !t.started {
+			t.columns = t.columns[:0]
+			for key := range flat {
+				t.columns = append(t.columns, key)
+			}
+			sort.Strings(t.columns)
+		}
+	}
+	if t.format == "json" {
+		data, err := json.Marshal(doc)
+		return [][]byte{data}, err
+	}
+
+	var outs [][]byte
+	if !t.started && t.header {
+		outs = append(outs, t.row(t.columns))
+	}
+	t.started = true
+	obj, _ := doc.(map[string]any)
+	cells := make([]string, len(t.columns))
+	for i, col := range t.columns {
+		cell, err := cellText(obj[col])
+		if err != nil {
+			return nil, err
+		}
+		cells[i] = cell
+	}
+	return append(outs, t.row(cells)), nil
+}
+
+// row formats a row of cells without the line terminator, which is added by the output framing.
+func (t *transform) row(cells []string) []byte {
+	if t.format == "tsv" {
+		escaped := make([]string, len(cells))
+		for i, cell := range cells {
+			escaped[i] = tsvEscaper.Replace(cell)
+		}
+		return []byte(strings.Join(escaped, "\t"))
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(cells)
+	w.Flush()
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+func cellText(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	}
+	data, err := json.Marshal(v)
+	return string(data), err
+}
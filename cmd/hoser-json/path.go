@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A path selects a value inside a record: .a.b selects the key b of the object at key a, .a[0] the first element of
+// the array at key a and .["some key"] a key that is not a plain name. The path . is the whole record. Outside
+// of expressions the leading dot can be left out, e.g. -select a.b,c.
+
+type pathElem struct {
+	key   string
+	index int
+	isIdx bool
+}
+
+type path struct {
+	text  string
+	elems []pathElem
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// parsePath parses a path, with or without its leading dot.
+func parsePath(s string) (path, error) {
+	p := path{text: s}
+	rest := s
+	if rest == "." {
+		return p, nil
+	}
+	if !strings.HasPrefix(rest, ".") && !strings.HasPrefix(rest, "[") {
+		rest = "." + rest
+	}
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".") && len(rest) > 1 && rest[1] == '[':
+			rest = rest[1:] // .["key"] is the same as ["key"]
+		case rest[0] == '.':
+			i := 1
+			for i < len(rest) && isNameChar(rest[i]) {
+				i++
+			}
+			if i == 1 {
+				return p, fmt.Errorf("invalid path '%s': expected a name after '.'", s)
+			}
+			p.elems = append(p.elems, pathElem{key: rest[1:i]})
+			rest = rest[i:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return p, fmt.Errorf("invalid path '%s': missing ']'", s)
+			}
+			inner := rest[1:end]
+			if strings.HasPrefix(inner, `"`) {
+				var key string
+				// the key may contain ']', so find the end of the string first
+				end = strings.Index(rest, `"]`)
+				if end < 0 {
+					return p, fmt.Errorf("invalid path '%s': missing '\"]'", s)
+				}
+				if err := json.Unmarshal([]byte(rest[1:end+1]), &key); err != nil {
+					return p, fmt.Errorf("invalid path '%s': %v", s, err)
+				}
+				p.elems = append(p.elems, pathElem{key: key})
+				rest = rest[end+2:]
+				continue
+			}
+			i, err := strconv.Atoi(inner)
+			if err != nil {
+				return p, fmt.Errorf("invalid path '%s': index '%s' is not a number", s, inner)
+			}
+			p.elems = append(p.elems, pathElem{index: i, isIdx: true})
+			rest = rest[end+1:]
+		default:
+			return p, fmt.Errorf("invalid path '%s' at '%s'", s, rest)
+		}
+	}
+	return p, nil
+}
+
+// get returns the value at the path and whether it exists. Negative indexes count from the end of an array.
+func (p path) get(doc any) (any, bool) {
+	v := doc
+	for _, e := range p.elems {
+		if e.isIdx {
+			arr, ok := v.([]any)
+			if !ok {
+				return nil, false
+			}
+			i := e.index
+			if i < 0 {
+				i += len(arr)
+			}
+			if i < 0 || i >= len(arr) {
+				return nil, false
+			}
+			v = arr[i]
+			continue
+		}
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[e.key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// name is the name of the path as a key of a projected object: its text without the leading dot.
+func (p path) name() string {
+	if p.text == "." {
+		return "."
+	}
+	return strings.TrimPrefix(p.text, ".")
+}
+
+// parsePaths parses a comma separated list of paths.
+func parsePaths(s string) ([]path, error) {
+	var paths []path
+	for _, text := range strings.Split(s, ",") {
+		p, err := parsePath(strings.TrimSpace(text))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// flatten stores every scalar of v under its dotted path, e.g. {"a": {"b": [1]}} as "a.b[0]": 1.
+func flatten(prefix string, v any, out map[string]any) {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, elem := range v {
+			name := key
+			if prefix != "" {
+				name = prefix + "." + key
+			}
+			flatten(name, elem, out)
+		}
+	case []any:
+		for i, elem := range v {
+			flatten(fmt.Sprintf("%s[%d]", prefix, i), elem, out)
+		}
+	default:
+		out[prefix] = v
+	}
+}
@@ -2,40 +2,74 @@ package main
 
 import (
 	"bufio"
-	"flag"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"net"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
-)
+	"syscall"
 
-const (
-	MaxRecordSize     = 1024 * 1024 * 48 // 48MB max record size
-	MaxStreamNameSize = 1024             // 1KB
+	"github.com/masp/hoser-runtime/node"
+	"github.com/masp/hoser-runtime/plan"
 )
 
+// hoser-merge copies the records of every input to stdout without splitting any record.
+// Inputs are the streams passed as arguments and any stream named on a line of stdin
+// (a path or a URI, see source.go), which can be added while the merge is running. The
+// order records are merged in is chosen with -strategy (see merge.go).
+//
+// Records are framed according to -framing and -sep (see node.FramingFlags), e.g.
+// -sep '\r\n' or -sep '\n\n' for multi-line records. Records of any size are merged:
+// delimited records larger than node.ChunkSize are streamed to stdout chunk by chunk
+// while the other sources wait, so they are never held in memory as a whole.
+//
+// On SIGINT or SIGTERM no more sources are accepted, the records that were already read
+// are written and hoser-merge exits cleanly, never leaving a partial record on stdout.
+// At exit and on SIGUSR1 it prints how many records and bytes were merged from every
+// source (see stats.go).
+
+const MaxStreamNameSize = 1024 // 1KB
+
 var (
-	sepCh byte
-	sep   = flag.String("sep", "\n", "the separator for continuous strings that will be copied atomically to stdout")
+	n            = node.New("hoser-merge")
+	framing      = n.FramingFlags()
+	strategyName = n.Flags.String("strategy", "any", "the order records are merged in: any, fair, priority or sorted")
+	key          = n.KeyFlags()
+	tagMode      = n.Flags.String("tag", "none", "mark every record with its source: none, prefix, tsv or json (see tag.go)")
+	events       = n.Flags.Bool("events", false, "write a control record when a source is added on stdin or reaches EOF")
+	allowExec    = n.Flags.Bool("allow-exec", false, "accept exec:// sources on stdin, which run whatever command they name")
+	listenPath   = n.Flags.String("listen", "", "listen on this Unix socket and merge every connection to it, until terminated")
+	statsPath    = n.Flags.String("stats", "", "write the per-source statistics printed at exit and on SIGUSR1 to this file as JSON (default: a table on stderr)")
+	tags         *tagger
+	_            = n.Input("stdin", plan.TypeStream, "names of additional streams to merge, one per line")
+	_            = n.Output("stdout", plan.TypeStream, "the merged records")
+	sources      = n.Inputs("inputs", "streams to merge")
 )
 
 func main() {
-	flag.Parse()
 	log.SetOutput(os.Stderr)
+	log.SetFlags(0)
+	n.Run(run)
+}
 
-	if len(*sep) > 1 {
-		log.Fatalf("sep only 1 byte")
+func run(ctx context.Context) error {
+	next, err := newStrategy(*strategyName, *key)
+	if err != nil {
+		return err
+	}
+	_, jsonValues := (*framing).(node.JSONLines)
+	if tags, err = newTagger(*tagMode, jsonValues); err != nil {
+		return err
 	}
-	sepCh = (*sep)[0]
 
-	var inputs []*os.File
-	for _, stream := range flag.Args() {
-		fd, err := os.Open(stream)
-		if err != nil {
-			log.Fatalf("File invalid: %v", err)
-		}
-		inputs = append(inputs, fd)
+	inputs, err := sources.OpenAll()
+	if err != nil {
+		return fmt.Errorf("File invalid: %w", err)
 	}
 	defer func() {
 		for _, input := range inputs {
@@ -43,37 +77,113 @@ func main() {
 		}
 	}()
 
-	records := make(chan []byte)
-	var wg sync.WaitGroup
-	for _, input := range inputs {
-		wg.Add(1)
-		go func(input *os.File) {
-			defer wg.Done()
-			copy(input.Name(), input, records)
-		}(input)
+	m := newMerger(ctx.Done())
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	defer signal.Stop(usr1)
+	go func() {
+		for range usr1 {
+			if err := m.stats.write(*statsPath); err != nil {
+				n.Errorf("write stats: %v", err)
+			}
+		}
+	}()
+	defer func() {
+		if err := m.stats.write(*statsPath); err != nil {
+			n.Errorf("write stats: %v", err)
+		}
+	}()
+	for i, input := range inputs {
+		m.addStatic(sources.Paths()[i], input)
 	}
-
-	wg.Add(1)
+	if *listenPath != "" {
+		l, err := net.Listen("unix", *listenPath)
+		if err != nil {
+			return err
+		}
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			listen(ctx, m, l)
+		}()
+	}
+	m.wg.Add(1)
 	go func() {
-		defer wg.Done()
-		readStdin(&wg, records)
+		defer m.wg.Done()
+		readStdin(ctx, m)
 	}()
-
 	go func() {
-		wg.Wait()
-		close(records)
+		m.wg.Wait()
+		close(m.added)
 	}()
 
-	for record := range records {
-		n, err := os.Stdout.Write(record)
+	out := (*framing).NewWriter(os.Stdout)
+	var total, totalBytes int64
+	write := func(rec record) bool {
+		size, err := writeRecord(out, rec)
 		if err != nil {
-			log.Printf("error: output write (%d/%d): %v", n, len(record), err)
-			return
+			n.Errorf("output write: %v", err)
+			return false
 		}
+		if rec.event == "" {
+			m.stats.count(rec.src.stats, size)
+		}
+		total++
+		totalBytes += size
+		n.Progress(total, totalBytes)
+		return true
 	}
+	for {
+		rec, ok := next(m)
+		if !ok {
+			break
+		}
+		if !write(rec) {
+			return nil
+		}
+	}
+	if ctx.Err() != nil {
+		// Terminated: write the records that were already read and stop without waiting for the sources
+		for _, rec := range m.drain() {
+			if !write(rec) {
+				break
+			}
+		}
+	}
+	return nil
 }
 
-func readStdin(wg *sync.WaitGroup, records chan []byte) {
+// writeRecord writes a record and, if it is streamed, all its remaining chunks. It returns the size of the record.
+func writeRecord(out node.RecordWriter, rec record) (int64, error) {
+	var data []byte
+	var err error
+	if rec.event != "" {
+		data, err = tags.event(rec.src.name, rec.event)
+	} else {
+		data, err = tags.tag(rec.src.name, rec.data)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if rec.more == nil {
+		return int64(len(data)), out.WriteRecord(data)
+	}
+	cw := out.(node.ChunkWriter)
+	size := int64(len(data))
+	err = cw.WriteChunk(data, true)
+	for chunk := range rec.more { // drain even after an error so the source is not blocked
+		size += int64(len(chunk))
+		if err == nil {
+			err = cw.WriteChunk(chunk, true)
+		}
+	}
+	if err == nil {
+		err = cw.WriteChunk(nil, false)
+	}
+	return size, err
+}
+
+func readStdin(ctx context.Context, m *merger) {
 	buf := bufio.NewReaderSize(os.Stdin, MaxStreamNameSize)
 	for {
 		newSrc, err := buf.ReadString('\n')
@@ -82,21 +192,12 @@ func readStdin(wg *sync.WaitGroup, records chan []byte) {
 			return
 		}
 
-		switch newSrc {
-		case "":
-			break
-		default:
-			// Treat it as a filename (unix)
-			fd, err := os.Open(newSrc)
-			if err != nil {
-				log.Printf("error: open file '%s' to merge: %v", newSrc, err)
-				continue
-			}
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				copy(newSrc, fd, records)
-			}()
+		if ctx.Err() != nil {
+			return
+		}
+		if newSrc != "" {
+			uri := newSrc
+			m.add(uri, func() (io.ReadCloser, error) { return openSource(ctx, uri) })
 		}
 
 		if err == io.EOF {
@@ -105,24 +206,141 @@ func readStdin(wg *sync.WaitGroup, records chan []byte) {
 	}
 }
 
-func copy(name string, from io.Reader, out chan []byte) {
-	bufrd := bufio.NewReaderSize(from, MaxRecordSize)
+// record is a record of a source. Records larger than a chunk are streamed: data is the first chunk and the
+// remaining chunks are sent on more, which is closed after the last one.
+type record struct {
+	src   *source
+	data  []byte
+	more  chan []byte
+	event string // Set for control records (-events), which have no data
+}
+
+// copyRecords sends the records of a source to src.records. With -events, it ends with an EOF control record.
+func (m *merger) copyRecords(src *source, from io.Reader) {
+	name, out := src.name, src.records
+	defer m.stats.eof(src.stats)
+	if *events {
+		defer func() { out <- record{src: src, event: eventEOF} }()
+	}
+	rd := (*framing).NewReader(from)
+	cr, stream := rd.(node.ChunkReader)
+	if _, ok := (*framing).NewWriter(io.Discard).(node.ChunkWriter); !ok || !tags.streamable() {
+		stream = false
+	}
 	for {
-		record, err := bufrd.ReadBytes(sepCh)
-		if err != nil && err != io.EOF {
-			log.Printf("copy: read '%s': %v", name, err)
-			return
+		if !stream {
+			data, err := rd.ReadRecord()
+			if err != nil {
+				reportRead(name, err)
+				return
+			}
+			out <- record{src: src, data: append([]byte(nil), data...)}
+			continue
 		}
 
-		if len(record) > 0 {
-			if record[len(record)-1] != sepCh {
-				record = append(record, sepCh) // for EOF, if there is no trailing sep, we add here
+		chunk, more, err := cr.ReadChunk()
+		if err != nil {
+			reportRead(name, err)
+			return
+		}
+		rec := record{src: src, data: append([]byte(nil), chunk...)}
+		if !more {
+			out <- rec
+			continue
+		}
+		rec.more = make(chan []byte)
+		out <- rec
+		for more && err == nil {
+			chunk, more, err = cr.ReadChunk()
+			if err == nil {
+				rec.more <- append([]byte(nil), chunk...)
 			}
-			out <- record
 		}
-
-		if err == io.EOF {
-			break
+		close(rec.more) // a record cut short by an error is ended where it was cut
+		if err != nil {
+			reportRead(name, err)
+			return
 		}
 	}
 }
+
+// reportRead reports an error reading a source, unless the source ended or was closed because hoser-merge is
+// exiting.
+func reportRead(name string, err error) {
+	if err != io.EOF && !errors.Is(err, os.ErrClosed) {
+		n.Errorf("copy: read '%s': %v", name, err)
+	}
+}
+
+// merger tracks every source being merged.
+type merger struct {
+	wg    sync.WaitGroup  // Done when stdin and every source reached EOF
+	added chan *source    // Sources added while merging, closed once wg is done
+	done  <-chan struct{} // Closed to stop merging, the strategies then return no more records
+	stats *stats
+
+	sources   []*source  // Sources known to the strategy, ordered by index
+	last      int        // The position in sources of the last record returned by receive
+	mu        sync.Mutex // Guards nextIndex, sources are added from stdin and -listen
+	nextIndex int
+}
+
+// source is a single input stream. Its records are read ahead into records, which is closed at EOF.
+type source struct {
+	name    string
+	stats   *sourceStats
+	index   int // The position of the source: arguments first, then in the order they are added on stdin
+	records chan record
+
+	head    record // The next record of the source, only used by sorted
+	hasHead bool
+}
+
+func newMerger(done <-chan struct{}) *merger {
+	return &merger{added: make(chan *source), done: done, stats: newStats()}
+}
+
+func (m *merger) newSource(name string) *source {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	src := &source{name: name, stats: m.stats.add(name), index: m.nextIndex, records: make(chan record, 1)}
+	m.nextIndex++
+	return src
+}
+
+// addStatic adds a source before merging starts.
+func (m *merger) addStatic(name string, rd io.Reader) {
+	src := m.newSource(name)
+	m.sources = append(m.sources, src)
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer close(src.records)
+		m.copyRecords(src, rd)
+	}()
+}
+
+// add opens and adds a source while merging. It must be called from a goroutine that keeps m.wg from completing.
+func (m *merger) add(name string, open func() (io.ReadCloser, error)) {
+	src := m.newSource(name)
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		rd, err := open()
+		if err != nil {
+			n.Errorf("open '%s' to merge: %v", name, err)
+			return
+		}
+		defer func() {
+			if err := rd.Close(); err != nil {
+				n.Errorf("close '%s': %v", name, err)
+			}
+		}()
+		m.added <- src
+		defer close(src.records)
+		if *events {
+			src.records <- record{src: src, event: eventAdd}
+		}
+		m.copyRecords(src, rd)
+	}()
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/masp/hoser-runtime/node"
+)
+
+// The strategies decide which source the next record is taken from:
+//
+//	any       whichever source has a record ready first
+//	fair      round-robin between the sources that have a record ready, so a fast source cannot starve the others
+//	priority  the first source (in argument order, then in the order they were added) that has a record ready
+//	sorted    k-way merge of sources that are sorted by -key, waits for every source to have a record ready
+//
+// With sorted, sources added on stdin are merged from the point they are added, so the output is only sorted if
+// they do not contain records smaller than the ones already written. The key of a streamed record is taken from
+// its first chunk.
+
+// strategy returns the next record to write, or false once every source reached EOF.
+type strategy func(m *merger) (record, bool)
+
+func newStrategy(name string, key node.Key) (strategy, error) {
+	switch name {
+	case "any":
+		return func(m *merger) (record, bool) { return m.receive(-1) }, nil
+	case "fair":
+		next := 0
+		return func(m *merger) (record, bool) {
+			record, ok := m.receive(next)
+			next = m.last + 1
+			return record, ok
+		}, nil
+	case "priority":
+		return func(m *merger) (record, bool) { return m.receive(0) }, nil
+	case "sorted":
+		return func(m *merger) (record, bool) { return m.receiveSorted(key) }, nil
+	default:
+		return nil, fmt.Errorf("unknown strategy '%s'", name)
+	}
+}
+
+// accept adds the sources that were added on stdin to m.sources. If block is set, it waits for a source to be
+// added. It returns false once no more sources can be added or m.done is closed.
+func (m *merger) accept(block bool) bool {
+	if m.added == nil {
+		return false
+	}
+	for {
+		var src *source
+		var ok bool
+		if block {
+			select {
+			case src, ok = <-m.added:
+			case <-m.done:
+				return false
+			}
+			block = false
+		} else {
+			select {
+			case src, ok = <-m.added:
+			default:
+				return true
+			}
+		}
+		if !ok {
+			m.added = nil
+			return false
+		}
+		m.insert(src)
+	}
+}
+
+func (m *merger) insert(src *source) {
+	i := sort.Search(len(m.sources), func(i int) bool { return m.sources[i].index > src.index })
+	m.sources = append(m.sources, nil)
+	copy(m.sources[i+1:], m.sources[i:])
+	m.sources[i] = src
+}
+
+func (m *merger) remove(i int) {
+	m.sources = append(m.sources[:i], m.sources[i+1:]...)
+}
+
+// receive returns the next record from any source. If start is not negative, the sources are first polled in
+// order beginning at start and the first ready record is returned. m.last is set to the position of the source
+// the record was taken from.
+func (m *merger) receive(start int) (record, bool) {
+	for {
+		m.accept(false)
+		if len(m.sources) == 0 {
+			if !m.accept(true) && len(m.sources) == 0 {
+				return record{}, false
+			}
+			continue
+		}
+
+		if start >= 0 {
+			if record, ok := m.poll(start); ok {
+				return record, true
+			}
+			if len(m.sources) == 0 { // every source reached EOF while polling
+				continue
+			}
+		}
+
+		// Nothing is ready, wait for any source or a new source
+		cases := make([]reflect.SelectCase, 0, len(m.sources)+1)
+		for _, src := range m.sources {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(src.records)})
+		}
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(m.done)})
+		if m.added != nil {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(m.added)})
+		}
+		chosen, value, ok := reflect.Select(cases)
+		if chosen == len(m.sources) {
+			return record{}, false
+		}
+		if chosen == len(m.sources)+1 { // m.added
+			if ok {
+				m.insert(value.Interface().(*source))
+			} else {
+				m.added = nil
+			}
+			continue
+		}
+		if !ok {
+			m.remove(chosen)
+			continue
+		}
+		m.last = chosen
+		return value.Interface().(record), true
+	}
+}
+
+// poll returns the first record that is ready from the sources in order beginning at start (wrapping around).
+func (m *merger) poll(start int) (record, bool) {
+	for i := 0; i < len(m.sources); i++ {
+		pos := (start + i) % len(m.sources)
+		select {
+		case record, ok := <-m.sources[pos].records:
+			if !ok {
+				m.remove(pos)
+				return m.poll(pos)
+			}
+			m.last = pos
+			return record, true
+		default:
+		}
+	}
+	return record{}, false
+}
+
+// receiveSorted returns the record with the smallest key among the next records of every source. Ties are broken
+// by the position of the source, so the merge is stable.
+func (m *merger) receiveSorted(key node.Key) (record, bool) {
+	for {
+		m.accept(false)
+		if len(m.sources) == 0 {
+			if !m.accept(true) && len(m.sources) == 0 {
+				return record{}, false
+			}
+			continue
+		}
+
+		for i := 0; i < len(m.sources); {
+			src := m.sources[i]
+			if !src.hasHead {
+				var rec record
+				var ok bool
+				select {
+				case rec, ok = <-src.records:
+				case <-m.done:
+					return record{}, false
+				}
+				if !ok {
+					m.remove(i)
+					continue
+				}
+				src.head, src.hasHead = rec, true
+			}
+			i++
+		}
+		if len(m.sources) == 0 {
+			continue
+		}
+
+		min := m.sources[0]
+		for _, src := range m.sources[1:] {
+			if key.Compare(src.head.data, min.head.data) < 0 {
+				min = src
+			}
+		}
+		min.hasHead = false
+		return min.head, true
+	}
+}
+
+// drain returns the records that were already read from the sources without waiting for more, in the order of
+// the sources. A source that keeps reading is not waited for, only the records buffered when drain is called are
+// returned. Writing a streamed record waits for its remaining chunks.
+func (m *merger) drain() []record {
+	var records []record
+	for _, src := range m.sources {
+		if src.hasHead {
+			records = append(records, src.head)
+			src.hasHead = false
+		}
+		select {
+		case rec, ok := <-src.records:
+			if ok {
+				records = append(records, rec)
+			}
+		default:
+		}
+	}
+	return records
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/masp/hoser-runtime/node"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startMerge merges the inputs as run does, without stdin. The sources are named by their position.
+func startMerge(t *testing.T, inputs ...string) *merger {
+	t.Helper()
+	var err error
+	tags, err = newTagger("none", false)
+	require.NoError(t, err)
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	m := newMerger(done)
+	for i, input := range inputs {
+		m.addStatic(string(rune('a'+i)), strings.NewReader(input))
+	}
+	go func() {
+		m.wg.Wait()
+		close(m.added)
+	}()
+	return m
+}
+
+// waitReady waits until the sources have their next record read ahead.
+func waitReady(t *testing.T, sources ...*source) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		for _, src := range sources {
+			if len(src.records) == 0 {
+				return false
+			}
+		}
+		return true
+	}, time.Second, time.Millisecond)
+}
+
+// next returns the source and data of the next record.
+func next(t *testing.T, m *merger, strategy strategy) string {
+	t.Helper()
+	rec, ok := strategy(m)
+	require.True(t, ok)
+	return rec.src.name + ":" + string(rec.data)
+}
+
+// mergeAll returns the data of every remaining record.
+func mergeAll(m *merger, strategy strategy) []string {
+	var got []string
+	for {
+		rec, ok := strategy(m)
+		if !ok {
+			return got
+		}
+		got = append(got, string(rec.data))
+	}
+}
+
+func TestStrategyAny(t *testing.T) {
+	any, err := newStrategy("any", node.Key{})
+	require.NoError(t, err)
+	m := startMerge(t, "1\n2\n", "3\n", "", "4\n5\n6\n")
+	assert.ElementsMatch(t, []string{"1", "2", "3", "4", "5", "6"}, mergeAll(m, any))
+
+	_, err = newStrategy("random", node.Key{})
+	assert.Error(t, err)
+}
+
+func TestStrategyFair(t *testing.T) {
+	fair, err := newStrategy("fair", node.Key{})
+	require.NoError(t, err)
+	m := startMerge(t, "1\n2\n3\n", "4\n5\n", "6\n")
+	a, b := m.sources[0], m.sources[1]
+	waitReady(t, m.sources...)
+	assert.Equal(t, "a:1", next(t, m, fair))
+	assert.Equal(t, "b:4", next(t, m, fair))
+	assert.Equal(t, "c:6", next(t, m, fair))
+	waitReady(t, a, b)
+	assert.Equal(t, "a:2", next(t, m, fair), "round-robin starts over once c is exhausted")
+	assert.Equal(t, "b:5", next(t, m, fair))
+	assert.Equal(t, []string{"3"}, mergeAll(m, fair))
+}
+
+func TestStrategyPriority(t *testing.T) {
+	priority, err := newStrategy("priority", node.Key{})
+	require.NoError(t, err)
+	m := startMerge(t, "1\n2\n", "3\n4\n")
+	for _, want := range []string{"a:1", "a:2"} {
+		waitReady(t, m.sources...)
+		assert.Equal(t, want, next(t, m, priority))
+	}
+	assert.Equal(t, []string{"3", "4"}, mergeAll(m, priority))
+}
+
+func TestStrategySorted(t *testing.T) {
+	sorted, err := newStrategy("sorted", node.Key{})
+	require.NoError(t, err)
+	m := startMerge(t, "a\nc\ne\n", "b\nd\n", "", "a\nf\n")
+	assert.Equal(t, []string{"a", "a", "b", "c", "d", "e", "f"}, mergeAll(m, sorted))
+
+	numeric, err := newStrategy("sorted", node.Key{Field: 2, Sep: ",", Numeric: true})
+	require.NoError(t, err)
+	m = startMerge(t, "x,2\nx,10\n", "y,9\ny,100\n")
+	assert.Equal(t, []string{"x,2", "y,9", "x,10", "y,100"}, mergeAll(m, numeric))
+}
+
+// TestLargeRecord merges a record larger than a chunk, which is streamed while the other source waits.
+func TestLargeRecord(t *testing.T) {
+	large := strings.Repeat("x", 3*node.ChunkSize)
+	m := startMerge(t, large+"\n", "small\n")
+	any, err := newStrategy("any", node.Key{})
+	require.NoError(t, err)
+
+	var out strings.Builder
+	wr := (*framing).NewWriter(&out)
+	for {
+		rec, ok := any(m)
+		if !ok {
+			break
+		}
+		_, err := writeRecord(wr, rec)
+		require.NoError(t, err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	assert.ElementsMatch(t, []string{large, "small"}, lines)
+}
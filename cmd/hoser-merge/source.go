@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Every line of stdin names a stream to add to the merge:
+//
+//	/path, file:///path  a file
+//	fifo:///path         a named pipe, merged once a writer opens it
+//	unix:///path         a connection to a Unix socket
+//	tcp://host:port      a TCP connection
+//	exec://cmd args      the stdout of cmd, run with sh -c (only with -allow-exec)
+//
+// With -listen, hoser-merge also listens on a Unix socket and every connection to it is a stream.
+
+// openSource opens the stream named by uri. It may block until the stream is ready, e.g. for a FIFO.
+func openSource(ctx context.Context, uri string) (io.ReadCloser, error) {
+	scheme, rest, ok := strings.Cut(uri, "://")
+	if !ok {
+		return os.Open(uri)
+	}
+	switch scheme {
+	case "file", "fifo":
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, err
+		}
+		return os.Open(u.Path)
+	case "unix":
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", rest)
+	case "tcp":
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", rest)
+	case "exec":
+		if !*allowExec {
+			return nil, fmt.Errorf("exec:// sources are only accepted with -allow-exec")
+		}
+		return startExec(ctx, rest)
+	default:
+		return nil, fmt.Errorf("unknown scheme '%s'", scheme)
+	}
+}
+
+// execSource is the stdout of a command. Closing it waits for the command.
+type execSource struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func startExec(ctx context.Context, command string) (io.ReadCloser, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &execSource{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+func (s *execSource) Close() error {
+	s.ReadCloser.Close()
+	if err := s.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %w", s.cmd.Args[2], err)
+	}
+	return nil
+}
+
+// listen adds every connection accepted by l as a source until ctx is done. It must be called from
+// a goroutine that keeps m.wg from completing.
+func listen(ctx context.Context, m *merger, l net.Listener) {
+	var once sync.Once
+	stop := func() { once.Do(func() { l.Close() }) }
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	defer stop()
+	for i := 1; ; i++ {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				n.Errorf("listen: %v", err)
+			}
+			return
+		}
+		m.add(fmt.Sprintf("%s#%d", l.Addr(), i), func() (io.ReadCloser, error) { return conn, nil })
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readSource(t *testing.T, uri string) string {
+	rd, err := openSource(context.Background(), uri)
+	require.NoError(t, err)
+	data, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	require.NoError(t, rd.Close())
+	return string(data)
+}
+
+// serve writes data to the first connection accepted by l.
+func serve(t *testing.T, l net.Listener, data string) {
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte(data))
+		conn.Close()
+	}()
+}
+
+func TestOpenSource(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, []byte("file\n"), 0o644))
+	assert.Equal(t, "file\n", readSource(t, file))
+	assert.Equal(t, "file\n", readSource(t, "file://"+file))
+
+	fifo := filepath.Join(dir, "fifo")
+	require.NoError(t, syscall.Mkfifo(fifo, 0o644))
+	go func() {
+		if wr, err := os.OpenFile(fifo, os.O_WRONLY, 0); err == nil {
+			wr.Write([]byte("fifo\n"))
+			wr.Close()
+		}
+	}()
+	assert.Equal(t, "fifo\n", readSource(t, "fifo://"+fifo))
+
+	sock := filepath.Join(dir, "sock")
+	l, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	serve(t, l, "unix\n")
+	assert.Equal(t, "unix\n", readSource(t, "unix://"+sock))
+
+	l, err = net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	serve(t, l, "tcp\n")
+	assert.Equal(t, "tcp\n", readSource(t, "tcp://"+l.Addr().String()))
+
+	_, err = openSource(context.Background(), "exec://echo a b")
+	assert.ErrorContains(t, err, "-allow-exec")
+	defer func(allowed bool) { *allowExec = allowed }(*allowExec)
+	*allowExec = true
+	assert.Equal(t, "a b\n", readSource(t, "exec://echo a b"))
+
+	_, err = openSource(context.Background(), "ftp://host/file")
+	assert.Error(t, err)
+	rd, err := openSource(context.Background(), "exec://exit 3")
+	require.NoError(t, err)
+	io.ReadAll(rd)
+	assert.Error(t, rd.Close())
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// stats counts the records and bytes written from every source. It is printed at exit and on SIGUSR1: as JSON
+// to the -stats file, or as a table on stderr without -stats (or with -stats -).
+type stats struct {
+	mu      sync.Mutex
+	start   time.Time
+	sources []*sourceStats
+}
+
+type sourceStats struct {
+	Name    string `json:"name"`
+	Records int64  `json:"records"`
+	Bytes   int64  `json:"bytes"`
+	EOF     bool   `json:"eof"` // Every record of the source was read
+}
+
+func newStats() *stats {
+	return &stats{start: time.Now()}
+}
+
+func (s *stats) add(name string) *sourceStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src := &sourceStats{Name: name}
+	s.sources = append(s.sources, src)
+	return src
+}
+
+func (s *stats) count(src *sourceStats, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src.Records++
+	src.Bytes += size
+}
+
+func (s *stats) eof(src *sourceStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src.EOF = true
+}
+
+// write writes the summary to path, or to stderr if path is "" or "-".
+func (s *stats) write(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summary := struct {
+		Elapsed string         `json:"elapsed"`
+		Records int64          `json:"records"`
+		Bytes   int64          `json:"bytes"`
+		Sources []*sourceStats `json:"sources"`
+	}{Elapsed: time.Since(s.start).Round(time.Millisecond).String(), Sources: s.sources}
+	for _, src := range s.sources {
+		summary.Records += src.Records
+		summary.Bytes += src.Bytes
+	}
+
+	if path == "" || path == "-" {
+		w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "SOURCE\tRECORDS\tBYTES\tEOF")
+		for _, src := range s.sources {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%t\n", src.Name, src.Records, src.Bytes, src.EOF)
+		}
+		fmt.Fprintf(w, "total\t%d\t%d\t%s\n", summary.Records, summary.Bytes, summary.Elapsed)
+		return w.Flush()
+	}
+
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Replace the file at once so readers never see a partial summary
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	s := newStats()
+	a, b := s.add("a"), s.add("b")
+	s.count(a, 3)
+	s.count(a, 5)
+	s.count(b, 1)
+	s.eof(a)
+
+	path := filepath.Join(t.TempDir(), "stats.json")
+	require.NoError(t, s.write(path))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var summary struct {
+		Records int64         `json:"records"`
+		Bytes   int64         `json:"bytes"`
+		Sources []sourceStats `json:"sources"`
+	}
+	require.NoError(t, json.Unmarshal(data, &summary))
+	assert.Equal(t, int64(3), summary.Records)
+	assert.Equal(t, int64(9), summary.Bytes)
+	assert.Equal(t, []sourceStats{{"a", 2, 8, true}, {"b", 1, 1, false}}, summary.Sources)
+
+	s.count(b, 1)
+	require.NoError(t, s.write(path), "the file is replaced")
+	matches, err := filepath.Glob(path + "*")
+	require.NoError(t, err)
+	assert.Equal(t, []string{path}, matches, "no temporary file is left behind")
+}
+
+func TestDrain(t *testing.T) {
+	m := startMerge(t, "1\n2\n", "3\n", "4\n")
+	a := m.sources[0]
+	waitReady(t, m.sources...)
+	sorted, err := newStrategy("sorted", *key)
+	require.NoError(t, err)
+	rec, ok := sorted(m) // every source now has its head taken out of its channel
+	require.True(t, ok)
+	assert.Equal(t, "1", string(rec.data))
+	waitReady(t, a)
+
+	var got []string
+	for _, rec := range m.drain() {
+		got = append(got, rec.src.name+":"+string(rec.data))
+	}
+	assert.Equal(t, []string{"a:2", "b:3", "c:4"}, got, "the records already read, in the order of the sources")
+	assert.Empty(t, m.drain())
+}
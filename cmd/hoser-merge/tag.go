@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// With -tag, every record is marked with the name of the source it came from:
+//
+//	prefix  name: record
+//	tsv     name<TAB>record
+//	json    {"src": "name", "rec": "record"}, with -framing jsonl the record is embedded as is
+//
+// With -events, a control record is written when a source is added on stdin and when a source reaches EOF. With
+// -tag json it is {"src": "name", "event": "add"} (or "eof"), otherwise it is the line "#hoser-merge add name"
+// (or eof), tagged like any other record.
+
+const (
+	eventAdd = "add"
+	eventEOF = "eof"
+)
+
+type tagger struct {
+	mode       string // "", "prefix", "tsv" or "json"
+	jsonValues bool   // The records are JSON values that can be embedded in the envelope
+}
+
+func newTagger(mode string, jsonValues bool) (*tagger, error) {
+	switch mode {
+	case "", "none":
+		return &tagger{}, nil
+	case "prefix", "tsv", "json":
+		return &tagger{mode: mode, jsonValues: jsonValues}, nil
+	default:
+		return nil, fmt.Errorf("unknown tag '%s'", mode)
+	}
+}
+
+// streamable reports if records can be tagged a chunk at a time, i.e. only their first chunk changes.
+func (t *tagger) streamable() bool {
+	return t.mode != "json"
+}
+
+// tag returns the record (or its first chunk) of src with its tag.
+func (t *tagger) tag(src string, data []byte) ([]byte, error) {
+	switch t.mode {
+	case "prefix":
+		return append([]byte(src+": "), data...), nil
+	case "tsv":
+		return append([]byte(src+"\t"), data...), nil
+	case "json":
+		envelope := struct {
+			Src string `json:"src"`
+			Rec any    `json:"rec"`
+		}{Src: src, Rec: string(data)}
+		if t.jsonValues {
+			envelope.Rec = json.RawMessage(data)
+		}
+		return json.Marshal(envelope)
+	default:
+		return data, nil
+	}
+}
+
+// event returns the control record for an event of src.
+func (t *tagger) event(src, event string) ([]byte, error) {
+	if t.mode == "json" {
+		return json.Marshal(struct {
+			Src   string `json:"src"`
+			Event string `json:"event"`
+		}{src, event})
+	}
+	return t.tag(src, []byte("#hoser-merge "+event+" "+src))
+}
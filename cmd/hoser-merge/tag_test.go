@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/masp/hoser-runtime/node"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTag(t *testing.T) {
+	tests := []struct {
+		mode       string
+		jsonValues bool
+		rec, event string
+	}{
+		{"none", false, `{"a":1}`, "#hoser-merge eof src"},
+		{"prefix", false, `src: {"a":1}`, "src: #hoser-merge eof src"},
+		{"tsv", false, "src\t{\"a\":1}", "src\t#hoser-merge eof src"},
+		{"json", false, `{"src":"src","rec":"{\"a\":1}"}`, `{"src":"src","event":"eof"}`},
+		{"json", true, `{"src":"src","rec":{"a":1}}`, `{"src":"src","event":"eof"}`},
+	}
+	for _, tt := range tests {
+		tagger, err := newTagger(tt.mode, tt.jsonValues)
+		require.NoError(t, err)
+		got, err := tagger.tag("src", []byte(`{"a":1}`))
+		require.NoError(t, err)
+		assert.Equal(t, tt.rec, string(got), tt.mode)
+		got, err = tagger.event("src", eventEOF)
+		require.NoError(t, err)
+		assert.Equal(t, tt.event, string(got), tt.mode)
+	}
+
+	_, err := newTagger("xml", false)
+	assert.Error(t, err)
+	tagger, err := newTagger("json", true)
+	require.NoError(t, err)
+	_, err = tagger.tag("src", []byte("not json"))
+	assert.Error(t, err)
+}
+
+func TestEvents(t *testing.T) {
+	defer func(enabled bool) { *events = enabled }(*events)
+	*events = true
+	var err error
+	tags, err = newTagger("prefix", false)
+	require.NoError(t, err)
+	m := newMerger(make(chan struct{}))
+	m.addStatic("a", strings.NewReader("1\n"))
+	m.wg.Add(1) // like stdin, keeps the merge going until b was added
+	m.add("b", func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("2\n")), nil })
+	m.wg.Done()
+	go func() {
+		m.wg.Wait()
+		close(m.added)
+	}()
+
+	any, err := newStrategy("any", node.Key{})
+	require.NoError(t, err)
+	var a, b []string
+	for {
+		rec, ok := any(m)
+		if !ok {
+			break
+		}
+		var out strings.Builder
+		_, err := writeRecord((*framing).NewWriter(&out), rec)
+		require.NoError(t, err)
+		if rec.src.name == "a" {
+			a = append(a, out.String())
+		} else {
+			b = append(b, out.String())
+		}
+	}
+	assert.Equal(t, []string{"a: 1\n", "a: #hoser-merge eof a\n"}, a, "sources given as arguments are not added")
+	assert.Equal(t, []string{"b: #hoser-merge add b\n", "b: 2\n", "b: #hoser-merge eof b\n"}, b)
+}
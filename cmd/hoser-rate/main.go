@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/masp/hoser-runtime/node"
+	"github.com/masp/hoser-runtime/plan"
+)
+
+// hoser-rate copies the records of stdin to stdout at most at -rate records and -bytes
+// bytes per second, e.g. to protect a service fed by the pipe. The limits are token
+// buckets (see rate.go): after a pause, up to -burst records and -burst-bytes bytes pass
+// at once. Records over the limit are delayed, which slows down the writer of stdin,
+// or with -drop dropped.
+//
+// Before the limits, records can be sampled: -every N keeps the first of every N
+// records and -sample F keeps each record with a probability of F. The random choices
+// depend only on -seed, so the same stream and seed give the same sample, e.g. for a
+// reproducible debug subset of a big stream.
+
+var (
+	n          = node.New("hoser-rate")
+	framing    = n.FramingFlags()
+	rate       = n.Flags.Float64("rate", 0, "the records per second let through (0 is no limit)")
+	byteRate   = n.Flags.Float64("bytes", 0, "the bytes per second let through (0 is no limit)")
+	burst      = n.Flags.Float64("burst", 0, "the records that can pass at once after a pause (default: -rate, at least 1)")
+	burstBytes = n.Flags.Float64("burst-bytes", 0, "the bytes that can pass at once after a pause (default: -bytes)")
+	drop       = n.Flags.Bool("drop", false, "drop the records over the limit instead of delaying them")
+	every      = n.Flags.Int64("every", 1, "keep only the first of every N records")
+	fraction   = n.Flags.Float64("sample", 1, "keep each record with this probability")
+	seed       = n.Flags.Int64("seed", 1, "the seed of the random choices of -sample")
+	stdin      = n.Input("stdin", plan.TypeStream, "the records")
+	stdout     = n.Output("stdout", plan.TypeStream, "the sampled records at the limited rate")
+)
+
+func main() {
+	log.SetOutput(os.Stderr)
+	log.SetFlags(0)
+	n.Run(run)
+}
+
+func run(ctx context.Context) error {
+	if *rate < 0 || *byteRate < 0 || *burst < 0 || *burstBytes < 0 {
+		return fmt.Errorf("-rate, -bytes, -burst and -burst-bytes cannot be negative")
+	}
+	if *every < 1 {
+		return fmt.Errorf("-every must be at least 1")
+	}
+	if *fraction <= 0 || *fraction > 1 {
+		return fmt.Errorf("-sample must be greater than 0 and at most 1")
+	}
+	now := time.Now()
+	lim := &limiter{}
+	if *rate > 0 {
+		b := *burst
+		if b == 0 {
+			b = *rate
+		}
+		if b < 1 {
+			b = 1
+		}
+		lim.records = newBucket(*rate, b, now)
+	}
+	if *byteRate > 0 {
+		b := *burstBytes
+		if b == 0 {
+			b = *byteRate
+		}
+		lim.bytes = newBucket(*byteRate, b, now)
+	}
+	s := newSampler(*every, *fraction, *seed)
+
+	in, err := stdin.Open()
+	if err != nil {
+		return err
+	}
+	out, err := stdout.Create()
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	rd := (*framing).NewReader(in)
+	wr := (*framing).NewWriter(out)
+	var records, totalBytes, sampledOut, dropped int64
+	defer func() {
+		if sampledOut > 0 {
+			n.Logf("sampled out %d records", sampledOut)
+		}
+		if dropped > 0 {
+			n.Logf("dropped %d records over the limit", dropped)
+		}
+	}()
+	for ctx.Err() == nil {
+		record, err := rd.ReadRecord()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("read stdin: %w", err)
+		}
+		if !s.keep() {
+			sampledOut++
+			continue
+		}
+		if d := lim.wait(len(record), time.Now()); d > 0 {
+			if *drop {
+				dropped++
+				continue
+			}
+			timer := time.NewTimer(d)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			}
+			lim.wait(len(record), time.Now()) // refill for the time waited
+		}
+		lim.take(len(record))
+		if err := wr.WriteRecord(record); err != nil {
+			return fmt.Errorf("write stdout: %w", err)
+		}
+		records++
+		totalBytes += int64(len(record))
+		n.Progress(records, totalBytes)
+	}
+	return nil
+}
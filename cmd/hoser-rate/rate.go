@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// bucket is a token bucket: it holds up to burst tokens and refills at rate tokens per second. A record takes one
+// token per record or per byte. A record larger than the burst is let through once the bucket is full, leaving the
+// bucket in debt, so that big records slow the stream down instead of blocking it forever.
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate, burst float64, now time.Time) *bucket {
+	return &bucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// wait returns how long to wait until n tokens can be taken, 0 if they can be taken now.
+func (b *bucket) wait(n float64, now time.Time) time.Duration {
+	b.refill(now)
+	if n > b.burst {
+		n = b.burst // let big records through once the bucket is full
+	}
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// take takes n tokens, which must be available according to wait.
+func (b *bucket) take(n float64) {
+	b.tokens -= n
+}
+
+// limiter applies the record and byte buckets, either of which may be nil.
+type limiter struct {
+	records, bytes *bucket
+}
+
+// wait returns how long to wait until a record of size bytes can pass.
+func (l *limiter) wait(size int, now time.Time) time.Duration {
+	var d time.Duration
+	if l.records != nil {
+		d = l.records.wait(1, now)
+	}
+	if l.bytes != nil {
+		if bd := l.bytes.wait(float64(size), now); bd > d {
+			d = bd
+		}
+	}
+	return d
+}
+
+func (l *limiter) take(size int) {
+	if l.records != nil {
+		l.records.take(1)
+	}
+	if l.bytes != nil {
+		l.bytes.take(float64(size))
+	}
+}
+
+// sampler decides which records are kept: every nth, or each with a probability of fraction drawn from a random
+// source with a fixed seed, so that the same stream always gives the same sample.
+type sampler struct {
+	every    int64
+	fraction float64
+	rnd      *rand.Rand
+	seen     int64
+}
+
+func newSampler(every int64, fraction float64, seed int64) *sampler {
+	return &sampler{every: every, fraction: fraction, rnd: rand.New(rand.NewSource(seed))}
+}
+
+func (s *sampler) keep() bool {
+	s.seen++
+	if s.every > 1 && (s.seen-1)%s.every != 0 {
+		return false
+	}
+	if s.fraction < 1 && s.rnd.Float64() >= s.fraction {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBucket(t *testing.T) {
+	start := time.Unix(0, 0)
+	lim := &limiter{records: newBucket(10, 2, start)}
+	// The burst passes at once, then one record every 100ms
+	for i := 0; i < 2; i++ {
+		assert.Zero(t, lim.wait(1, start))
+		lim.take(1)
+	}
+	assert.Equal(t, 100*time.Millisecond, lim.wait(1, start))
+	assert.Equal(t, 50*time.Millisecond, lim.wait(1, start.Add(50*time.Millisecond)))
+	assert.Zero(t, lim.wait(1, start.Add(100*time.Millisecond)))
+	lim.take(1)
+	// A long pause only refills the burst
+	assert.Zero(t, lim.wait(1, start.Add(time.Hour)))
+	lim.take(1)
+	lim.take(1)
+	assert.NotZero(t, lim.wait(1, start.Add(time.Hour)))
+
+	// Bytes: a record larger than the burst passes when the bucket is full and leaves it in debt
+	lim = &limiter{bytes: newBucket(100, 50, start)}
+	assert.Zero(t, lim.wait(200, start))
+	lim.take(200)
+	assert.Equal(t, 2*time.Second, lim.wait(50, start))
+
+	// The slower of both limits wins
+	lim = &limiter{records: newBucket(1000, 1, start), bytes: newBucket(10, 10, start)}
+	lim.take(10)
+	assert.Equal(t, time.Second, lim.wait(10, start))
+}
+
+func TestSampler(t *testing.T) {
+	s := newSampler(3, 1, 1)
+	var kept []int
+	for i := 1; i <= 10; i++ {
+		if s.keep() {
+			kept = append(kept, i)
+		}
+	}
+	assert.Equal(t, []int{1, 4, 7, 10}, kept)
+
+	sample := func(seed int64) []int {
+		s := newSampler(1, 0.1, seed)
+		var kept []int
+		for i := 0; i < 10000; i++ {
+			if s.keep() {
+				kept = append(kept, i)
+			}
+		}
+		return kept
+	}
+	a := sample(42)
+	assert.Equal(t, a, sample(42))
+	assert.NotEqual(t, a, sample(43))
+	assert.InDelta(t, 1000, len(a), 150)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/masp/hoser-runtime/node"
+	"github.com/masp/hoser-runtime/plan"
+)
+
+// hoser-sort sorts the records of stdin by -key (see node.KeyFlags) and writes them to
+// stdout. A record spanning several lines (see -sep) sorts as a unit. Streams larger
+// than -mem are sorted with bounded memory by spilling sorted runs to temporary files
+// and merging them (see sort.go). The sort is stable: records with equal keys keep
+// their input order.
+
+var (
+	n        = node.New("hoser-sort")
+	framing  = n.FramingFlags()
+	key      = n.KeyFlags()
+	reverse  = n.Flags.Bool("r", false, "sort in descending order")
+	memLimit = n.Flags.Int64("mem", 256<<20, "the bytes of records kept in memory before a sorted run is spilled to disk")
+	tmpDir   = n.Flags.String("tmp", "", "directory for the sorted runs (default: the temporary directory)")
+	stdin    = n.Input("stdin", plan.TypeStream, "the records to sort")
+	stdout   = n.Output("stdout", plan.TypeStream, "the sorted records")
+)
+
+func main() {
+	log.SetOutput(os.Stderr)
+	log.SetFlags(0)
+	n.Run(run)
+}
+
+func run(ctx context.Context) error {
+	if *memLimit <= 0 {
+		return fmt.Errorf("-mem must be positive")
+	}
+	in, err := stdin.Open()
+	if err != nil {
+		return err
+	}
+	out, err := stdout.Create()
+	if err != nil {
+		return err
+	}
+
+	s := &sorter{key: *key, reverse: *reverse, limit: *memLimit, dir: *tmpDir}
+	rd := (*framing).NewReader(in)
+	var total, totalBytes int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		record, err := rd.ReadRecord()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("read stdin: %w", err)
+		}
+		if err := s.add(record); err != nil {
+			return fmt.Errorf("spill run: %w", err)
+		}
+		total++
+		totalBytes += int64(len(record))
+		n.Progress(total, totalBytes)
+	}
+	if len(s.runs) > 0 {
+		n.Logf("merging %d sorted runs", len(s.runs)+1)
+	}
+	if err := s.output((*framing).NewWriter(out)); err != nil {
+		return fmt.Errorf("write stdout: %w", err)
+	}
+	return nil
+}
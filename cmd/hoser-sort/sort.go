@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/masp/hoser-runtime/node"
+)
+
+// recordOverhead approximates the memory used by a record besides its bytes, so that many tiny records still
+// count against the limit.
+const recordOverhead = 32
+
+// maxRuns is the number of runs kept open. Once reached, the runs are merged into a single run.
+const maxRuns = 64
+
+// sorter sorts records with bounded memory: records are collected until they use limit bytes, then they are
+// sorted and written to a temporary file as a sorted run. At the end the runs (and the records still in memory)
+// are merged. The sort is stable.
+type sorter struct {
+	key     node.Key
+	reverse bool
+	limit   int64
+	dir     string // Where runs are written, "" for the default temporary directory
+
+	records [][]byte
+	size    int64
+	runs    []*os.File
+}
+
+func (s *sorter) less(a, b []byte) bool {
+	c := s.key.Compare(a, b)
+	if s.reverse {
+		c = -c
+	}
+	return c < 0
+}
+
+// add adds a copy of the record, spilling a run if the memory limit is reached.
+func (s *sorter) add(record []byte) error {
+	s.records = append(s.records, append([]byte(nil), record...))
+	s.size += int64(len(record)) + recordOverhead
+	if s.size >= s.limit {
+		return s.spill()
+	}
+	return nil
+}
+
+func (s *sorter) sortMemory() {
+	sort.SliceStable(s.records, func(i, j int) bool { return s.less(s.records[i], s.records[j]) })
+}
+
+// spill writes the records in memory to a new run.
+func (s *sorter) spill() error {
+	s.sortMemory()
+	mem := &memoryRun{records: s.records}
+	f, err := s.writeRun(func(w node.RecordWriter) error { return copyRecords(w, mem) })
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f)
+	s.records, s.size = nil, 0
+	if len(s.runs) < maxRuns {
+		return nil
+	}
+
+	// Merge the runs so that the number of open files stays bounded however large the input is
+	runs := s.runs
+	f, err = s.writeRun(func(w node.RecordWriter) error { return s.merge(w, s.readers(runs)) })
+	for _, run := range runs {
+		run.Close()
+	}
+	s.runs = nil
+	if err != nil {
+		return err
+	}
+	s.runs = []*os.File{f}
+	return nil
+}
+
+// writeRun creates a run file with the records written by write and rewinds it for reading.
+func (s *sorter) writeRun(write func(w node.RecordWriter) error) (*os.File, error) {
+	f, err := os.CreateTemp(s.dir, "hoser-sort-")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(f.Name()) // the run only lives as long as it is open
+	buf := bufio.NewWriter(f)
+	err = write(node.LengthPrefixed{}.NewWriter(buf))
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *sorter) readers(runs []*os.File) []node.RecordReader {
+	readers := make([]node.RecordReader, len(runs))
+	for i, f := range runs {
+		readers[i] = node.LengthPrefixed{}.NewReader(f)
+	}
+	return readers
+}
+
+func copyRecords(w node.RecordWriter, rd node.RecordReader) error {
+	for {
+		record, err := rd.ReadRecord()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := w.WriteRecord(record); err != nil {
+			return err
+		}
+	}
+}
+
+// output writes every record in order and releases the runs.
+func (s *sorter) output(w node.RecordWriter) error {
+	defer func() {
+		for _, f := range s.runs {
+			f.Close()
+		}
+	}()
+	s.sortMemory()
+	mem := &memoryRun{records: s.records}
+	if len(s.runs) == 0 {
+		return copyRecords(w, mem)
+	}
+	// The records in memory are the last run, so ties still keep the input order
+	return s.merge(w, append(s.readers(s.runs), mem))
+}
+
+// merge writes the records of sorted runs in order. Ties are written in the order of the runs.
+func (s *sorter) merge(w node.RecordWriter, runs []node.RecordReader) error {
+	h := &runHeap{less: s.less}
+	for i, rd := range runs {
+		r := &sortedRun{index: i, rd: rd}
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h.runs = append(h.runs, r)
+		}
+	}
+	heap.Init(h)
+	for h.Len() > 0 {
+		r := h.runs[0]
+		if err := w.WriteRecord(r.head); err != nil {
+			return err
+		}
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
+
+// memoryRun reads the records that are still in memory like a run.
+type memoryRun struct {
+	records [][]byte
+}
+
+func (m *memoryRun) ReadRecord() ([]byte, error) {
+	if len(m.records) == 0 {
+		return nil, io.EOF
+	}
+	record := m.records[0]
+	m.records = m.records[1:]
+	return record, nil
+}
+
+type sortedRun struct {
+	index int
+	rd    node.RecordReader
+	head  []byte // The smallest record of the run not written yet
+}
+
+func (r *sortedRun) next() (bool, error) {
+	record, err := r.rd.ReadRecord()
+	if err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	r.head = record
+	return true, nil
+}
+
+// runHeap orders the runs by their head, ties by the order of the runs.
+type runHeap struct {
+	runs []*sortedRun
+	less func(a, b []byte) bool
+}
+
+func (h *runHeap) Len() int { return len(h.runs) }
+func (h *runHeap) Less(i, j int) bool {
+	a, b := h.runs[i], h.runs[j]
+	if h.less(a.head, b.head) {
+		return true
+	}
+	if h.less(b.head, a.head) {
+		return false
+	}
+	return a.index < b.index
+}
+func (h *runHeap) Swap(i, j int) { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+func (h *runHeap) Push(x any)    { h.runs = append(h.runs, x.(*sortedRun)) }
+func (h *runHeap) Pop() any {
+	r := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return r
+}
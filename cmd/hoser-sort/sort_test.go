@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/masp/hoser-runtime/node"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sortRecords(t *testing.T, s *sorter, records []string) []string {
+	for _, rec := range records {
+		require.NoError(t, s.add([]byte(rec)))
+	}
+	var buf bytes.Buffer
+	require.NoError(t, s.output(node.Delim("\n").NewWriter(&buf)))
+	return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+}
+
+func TestSortRuns(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	var records []string
+	for i := 0; i < 1000; i++ {
+		records = append(records, fmt.Sprintf("%d %d", rnd.Intn(100), i))
+	}
+	want := append([]string(nil), records...)
+	key := node.Key{Field: 1, Numeric: true}
+	sort.SliceStable(want, func(i, j int) bool { return key.Compare([]byte(want[i]), []byte(want[j])) < 0 })
+
+	s := &sorter{key: key, limit: 2000, dir: t.TempDir()}
+	got := sortRecords(t, s, records)
+	assert.Greater(t, len(s.runs), 10)
+	assert.Equal(t, want, got, "sorted and stable across runs")
+
+	s = &sorter{key: key, reverse: true, limit: 1 << 20}
+	assert.Equal(t, []string{"3 a", "2", "1 b", "1 c"}, sortRecords(t, s, []string{"1 b", "3 a", "1 c", "2"}))
+}
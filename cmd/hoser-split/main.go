@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+
+	"github.com/masp/hoser-runtime/node"
+	"github.com/masp/hoser-runtime/plan"
+)
+
+// hoser-split routes every record of stdin to exactly one of its outputs, so that a
+// stream can be partitioned between parallel stages and put back together with
+// hoser-merge. Records are framed according to -framing and -sep and written to the
+// outputs with a single write each. How the output is chosen is set with -by (see
+// route.go).
+//
+// Outputs are written in turn, so an output that is not read blocks the others; put a
+// hoser-buffer in front of consumers that are bursty.
+
+var (
+	n        = node.New("hoser-split")
+	framing  = n.FramingFlags()
+	key      = n.KeyFlags()
+	by       = n.Flags.String("by", "roundrobin", "how records are routed to the outputs: roundrobin, hash or regex")
+	patterns []*regexp.Regexp
+	stdin    = n.Input("stdin", plan.TypeStream, "the records to split")
+	outputs  = n.Outputs("outputs", "the partitions")
+)
+
+func init() {
+	n.Flags.Func("regex", "with -by regex, records whose key matches go to the next output, can be repeated", func(s string) error {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return err
+		}
+		patterns = append(patterns, re)
+		return nil
+	})
+}
+
+func main() {
+	log.SetOutput(os.Stderr)
+	log.SetFlags(0)
+	n.Run(run)
+}
+
+func run(ctx context.Context) error {
+	if len(outputs.Paths()) == 0 {
+		return fmt.Errorf("no outputs given")
+	}
+	route, err := newRouter(*by, *key, patterns, len(outputs.Paths()))
+	if err != nil {
+		return err
+	}
+	in, err := stdin.Open()
+	if err != nil {
+		return err
+	}
+	files, err := outputs.CreateAll()
+	if err != nil {
+		return err
+	}
+	writers := make([]node.RecordWriter, len(files))
+	for i, f := range files {
+		defer f.Close()
+		writers[i] = (*framing).NewWriter(f)
+	}
+
+	rd := (*framing).NewReader(in)
+	var total, totalBytes, unmatched int64
+	for ctx.Err() == nil {
+		record, err := rd.ReadRecord()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("read stdin: %w", err)
+		}
+		i := route(record)
+		if i < 0 {
+			unmatched++
+			continue
+		}
+		if err := writers[i].WriteRecord(record); err != nil {
+			return fmt.Errorf("write '%s': %w", outputs.Paths()[i], err)
+		}
+		total++
+		totalBytes += int64(len(record))
+		n.Progress(total, totalBytes)
+	}
+	if unmatched > 0 {
+		n.Logf("dropped %d records that matched no -regex", unmatched)
+	}
+	return nil
+}
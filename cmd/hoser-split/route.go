@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+
+	"github.com/masp/hoser-runtime/node"
+)
+
+// The output of a record is chosen by -by:
+//
+//	roundrobin  the outputs in turn
+//	hash        the FNV-1a hash of the key (see -key) modulo the number of outputs, so equal keys always go to
+//	            the same output
+//	regex       the output of the first -regex that matches the key. With one pattern less than outputs, the
+//	            last output gets the records that match no pattern, otherwise they are dropped
+//
+// A router returns the index of the output or -1 if the record is dropped.
+type router func(record []byte) int
+
+func newRouter(by string, key node.Key, patterns []*regexp.Regexp, outputs int) (router, error) {
+	if by != "regex" && len(patterns) > 0 {
+		return nil, fmt.Errorf("-regex requires -by regex")
+	}
+	switch by {
+	case "roundrobin":
+		next := 0
+		return func([]byte) int {
+			i := next
+			next = (next + 1) % outputs
+			return i
+		}, nil
+	case "hash":
+		return func(record []byte) int {
+			h := fnv.New32a()
+			h.Write([]byte(key.MapKey(key.Extract(record))))
+			return int(h.Sum32() % uint32(outputs))
+		}, nil
+	case "regex":
+		if len(patterns) != outputs && len(patterns) != outputs-1 {
+			return nil, fmt.Errorf("%d -regex patterns for %d outputs, give one per output or one less for a default output", len(patterns), outputs)
+		}
+		return func(record []byte) int {
+			k := key.Extract(record)
+			for i, re := range patterns {
+				if re.Match(k) {
+					return i
+				}
+			}
+			if len(patterns) < outputs {
+				return outputs - 1
+			}
+			return -1
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown -by '%s'", by)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/masp/hoser-runtime/node"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func routeAll(route router, records ...string) []int {
+	var got []int
+	for _, rec := range records {
+		got = append(got, route([]byte(rec)))
+	}
+	return got
+}
+
+func TestRouter(t *testing.T) {
+	route, err := newRouter("roundrobin", node.Key{}, nil, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 0}, routeAll(route, "a", "b", "c", "d"))
+
+	route, err = newRouter("hash", node.Key{Field: 1}, nil, 4)
+	require.NoError(t, err)
+	got := routeAll(route, "x 1", "y 2", "x 3")
+	assert.Equal(t, got[0], got[2], "equal keys go to the same output")
+
+	route, err = newRouter("hash", node.Key{Field: 2, Numeric: true}, nil, 4)
+	require.NoError(t, err)
+	got = routeAll(route, "x 1", "y 1.0", "z 01", "w 1e0")
+	assert.Equal(t, []int{got[0], got[0], got[0], got[0]}, got, "numerically equal keys go to the same output")
+
+	patterns := []*regexp.Regexp{regexp.MustCompile("^err"), regexp.MustCompile("^warn")}
+	route, err = newRouter("regex", node.Key{}, patterns, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 0, 2}, routeAll(route, "warn: x", "error: y", "info: z"))
+	route, err = newRouter("regex", node.Key{}, patterns, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []int{-1}, routeAll(route, "info: z"))
+
+	_, err = newRouter("regex", node.Key{}, patterns, 4)
+	assert.Error(t, err)
+	_, err = newRouter("hash", node.Key{}, patterns, 2)
+	assert.Error(t, err)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/masp/hoser-runtime/node"
+	"github.com/masp/hoser-runtime/plan"
+)
+
+// hoser-tail follows the files and directories given as arguments and writes their
+// records to stdout as they are appended, so that a long-running pipe can be driven by
+// log files or by files landing in a directory. A record is only written once it is
+// complete. Records of different files are interleaved, each with a single write.
+//
+// Files are followed across rotation and truncation (see tail.go). Every file in a
+// directory argument whose name matches -glob is followed, including the files created
+// later. On Linux, changes are noticed right away with inotify, elsewhere every -poll.
+//
+// With -markers, a record is written before the first record of every file that is
+// started: {"event": "start", "file": "path"} with -framing jsonl, otherwise the line
+// "#hoser-tail start path".
+
+var (
+	n       = node.New("hoser-tail")
+	framing = n.FramingFlags()
+	from    = n.Flags.String("from", "start", "where to start reading the files that exist at start: start or end")
+	glob    = n.Flags.String("glob", "*", "the files to follow in directory arguments")
+	poll    = n.Flags.Duration("poll", time.Second, "how often files are checked for changes that inotify did not report")
+	markers = n.Flags.Bool("markers", false, "write a marker record whenever a file is started")
+	stdout  = n.Output("stdout", plan.TypeStream, "the records of the files")
+)
+
+func main() {
+	log.SetOutput(os.Stderr)
+	log.SetFlags(0)
+	n.Run(run)
+}
+
+func run(ctx context.Context) error {
+	paths := n.Args()
+	if len(paths) == 0 {
+		return fmt.Errorf("no files or directories given")
+	}
+	if *from != "start" && *from != "end" {
+		return fmt.Errorf("-from must be start or end")
+	}
+	if *poll <= 0 {
+		return fmt.Errorf("-poll must be positive")
+	}
+	if _, err := filepath.Match(*glob, ""); err != nil {
+		return fmt.Errorf("-glob: %w", err)
+	}
+	out, err := stdout.Create()
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	t := &tailer{nt: newNotifier(), records: make(chan []byte, 64)}
+	var dirs []*dirWatch
+	var files []*follower
+	watched := make(map[string]bool) // The directories inotify watches
+	for _, path := range paths {
+		if st, err := os.Stat(path); err == nil && st.IsDir() {
+			dirs = append(dirs, &dirWatch{t: t, dir: path, glob: *glob})
+			watched[path] = true
+		} else {
+			files = append(files, &follower{t: t, path: path, fromEnd: *from == "end", persistent: true})
+			watched[filepath.Dir(path)] = true
+		}
+	}
+	var watchDirs []string
+	for dir := range watched {
+		watchDirs = append(watchDirs, dir)
+	}
+	if err := watch(ctx, watchDirs, t.nt); err != nil {
+		n.Logf("%v, checking files every %s", err, *poll)
+	}
+	go func() {
+		ticker := time.NewTicker(*poll)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.nt.notify()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, f := range files {
+		wg.Add(1)
+		go func(f *follower) {
+			defer wg.Done()
+			f.run(ctx)
+		}(f)
+	}
+	for _, d := range dirs {
+		wg.Add(1)
+		go func(d *dirWatch) {
+			defer wg.Done()
+			d.run(ctx, *from == "end", &wg)
+		}(d)
+	}
+	go func() {
+		wg.Wait()
+		close(t.records)
+	}()
+
+	wr := (*framing).NewWriter(out)
+	var records, totalBytes int64
+	for record := range t.records {
+		if err := wr.WriteRecord(record); err != nil {
+			cancel()
+			return fmt.Errorf("write stdout: %w", err)
+		}
+		records++
+		totalBytes += int64(len(record))
+		n.Progress(records, totalBytes)
+	}
+	return nil
+}
+
+// tailer collects the records of every followed file.
+type tailer struct {
+	nt      *notifier
+	records chan []byte
+}
+
+// send queues a record of a file. It returns false when ctx is done.
+func (t *tailer) send(ctx context.Context, record []byte) bool {
+	select {
+	case t.records <- append([]byte(nil), record...):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// start queues the marker for a file that is started, if -markers is set.
+func (t *tailer) start(ctx context.Context, path string) bool {
+	if !*markers {
+		return true
+	}
+	marker := []byte("#hoser-tail start " + path)
+	if _, ok := (*framing).(node.JSONLines); ok {
+		marker, _ = json.Marshal(struct {
+			Event string `json:"event"`
+			File  string `json:"file"`
+		}{"start", path})
+	}
+	return t.send(ctx, marker)
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/masp/hoser-runtime/node"
+)
+
+// Files are followed by name like tail -F. When a followed file is found to be truncated (it is smaller than what
+// was read, or its first bytes changed) it is read again from the start. When it is replaced, e.g. renamed away by
+// logrotate and created anew, the rest of the old file is read and then the new file from its start; a last record
+// of the old file that is not complete is dropped. A file that does not exist yet, or was removed, is waited for
+// and read from its start once it appears, also with -from end.
+//
+// Files found in a watched directory are followed by identity instead of name: a file that is renamed is still
+// followed as long as its new name matches -glob, and is not read again under its new name. A file created under
+// the old name is found by the next scan of the directory like any other new file.
+
+// notifier wakes up everything that waits for a file to change: on inotify events, and every -poll.
+type notifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func newNotifier() *notifier {
+	return &notifier{ch: make(chan struct{})}
+}
+
+// wait returns a channel that is closed on the next notify. Get it before checking for changes, so that a change
+// right after the check is not missed.
+func (nt *notifier) wait() <-chan struct{} {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+	return nt.ch
+}
+
+func (nt *notifier) notify() {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+	close(nt.ch)
+	nt.ch = make(chan struct{})
+}
+
+// errGone ends the records of a file that is no longer followed. It is not io.EOF, so that the framing drops a
+// last record that was cut short instead of taking it as complete.
+var errGone = errors.New("file is no longer followed")
+
+// headSize is how many bytes at the start of a file are compared to notice that it was truncated and written again.
+const headSize = 64
+
+// followReader reads a growing file. At the end of the file, Read waits for more data instead of returning io.EOF.
+// It returns errGone once gone reports that the file is no longer followed and it was read to its end.
+type followReader struct {
+	ctx  context.Context
+	nt   *notifier
+	path string
+	f    *os.File
+	off  int64  // What was read so far
+	head []byte // The first bytes of the file, up to headSize
+	last []byte // The last bytes read, to tell whether the last record is complete
+	gone func(os.FileInfo) bool
+}
+
+func (r *followReader) Read(p []byte) (int, error) {
+	woken := false // Read waited at the end of the file, which may have been truncated since
+	for {
+		changed := r.nt.wait()
+		if woken {
+			st, err := r.f.Stat()
+			if err != nil {
+				return 0, err
+			}
+			truncated, err := r.truncated(st)
+			if err != nil {
+				return 0, err
+			}
+			if truncated {
+				n.Logf("%s: file truncated, reading it from the start", r.path)
+				if _, err := r.f.Seek(0, io.SeekStart); err != nil {
+					return 0, err
+				}
+				r.off, r.head = 0, nil
+			}
+		}
+		read, err := r.f.Read(p)
+		if read > 0 {
+			r.off += int64(read)
+			r.keepLast(p[:read])
+			return read, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		st, err := r.f.Stat()
+		if err != nil {
+			return 0, err
+		}
+		if r.gone(st) {
+			// Only what was written before it is left to read
+			read, err := r.f.Read(p)
+			r.off += int64(read)
+			r.keepLast(p[:read])
+			if read > 0 || err != io.EOF {
+				return read, err
+			}
+			return 0, errGone
+		}
+		if _, err := r.truncated(st); err != nil { // remember the first bytes to compare after waking up
+			return 0, err
+		}
+
+		select {
+		case <-changed:
+			woken = true
+		case <-r.ctx.Done():
+			return 0, r.ctx.Err()
+		}
+	}
+}
+
+// truncated reports whether the file was truncated since it was read: it is smaller than what was read, or it
+// starts with other bytes than before, which happens when it was truncated and written past the read offset
+// before Read noticed.
+func (r *followReader) truncated(st os.FileInfo) (bool, error) {
+	if st.Size() < r.off {
+		return true, nil
+	}
+	size := r.off
+	if size > headSize {
+		size = headSize
+	}
+	if size == 0 {
+		return false, nil
+	}
+	buf := make([]byte, size)
+	if _, err := r.f.ReadAt(buf, 0); err != nil {
+		return false, err
+	}
+	if !bytes.HasPrefix(buf, r.head) { // the file only grew since head was read
+		return true, nil
+	}
+	r.head = buf
+	return false, nil
+}
+
+func (r *followReader) keepLast(p []byte) {
+	r.last = append(r.last, p...)
+	if len(r.last) > 16 {
+		r.last = append(r.last[:0], r.last[len(r.last)-16:]...)
+	}
+}
+
+// incomplete reports whether the data read so far ends in the middle of a record, as far as it can be told.
+func (r *followReader) incomplete() bool {
+	d, ok := (*framing).(node.Delim)
+	return ok && r.off > 0 && !bytes.HasSuffix(r.last, []byte(d))
+}
+
+// follower follows one file and sends its records.
+type follower struct {
+	t          *tailer
+	path       string
+	fromEnd    bool      // Start at the end of the file that exists when following starts
+	persistent bool      // Keep following the name after the file was replaced or removed
+	dir        *dirWatch // The directory the file was found in, if any
+}
+
+// gone reports whether the open file st is no longer followed: for a file given by name, once another file or
+// none is at path; for a file of a directory, once it was removed from the directory.
+func (f *follower) gone(st os.FileInfo) bool {
+	if f.dir != nil {
+		return f.dir.gone(st)
+	}
+	cur, err := os.Stat(f.path)
+	return err != nil || !os.SameFile(cur, st)
+}
+
+func (f *follower) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		changed := f.t.nt.wait()
+		file, err := os.Open(f.path)
+		if errors.Is(err, os.ErrNotExist) {
+			f.fromEnd = false // the file is new once it appears
+		}
+		if errors.Is(err, os.ErrNotExist) && f.persistent {
+			select {
+			case <-changed:
+			case <-ctx.Done():
+			}
+			continue
+		} else if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				n.Errorf("%v", err)
+			}
+			return
+		}
+		f.follow(ctx, file)
+		file.Close()
+		if !f.persistent {
+			return
+		}
+	}
+}
+
+// follow reads the records of an open file until it is replaced or removed.
+func (f *follower) follow(ctx context.Context, file *os.File) {
+	st, err := file.Stat()
+	if err != nil {
+		n.Errorf("%v", err)
+		return
+	}
+	if f.dir != nil {
+		f.dir.markSeen(st)
+	}
+	r := &followReader{ctx: ctx, nt: f.t.nt, path: f.path, f: file, gone: f.gone}
+	if f.fromEnd {
+		if r.off, err = file.Seek(0, io.SeekEnd); err != nil {
+			n.Errorf("%v", err)
+			return
+		}
+	}
+	f.fromEnd = false // the files that replace it are new, they are read from the start
+	if !f.t.start(ctx, f.path) {
+		return
+	}
+	rd := (*framing).NewReader(r)
+	for {
+		record, err := rd.ReadRecord()
+		if errors.Is(err, errGone) {
+			if r.incomplete() {
+				n.Logf("%s: dropping the incomplete last record", f.path)
+			}
+			return
+		} else if err != nil {
+			if ctx.Err() == nil {
+				n.Errorf("%s: %v", f.path, err)
+			}
+			return
+		}
+		if !f.t.send(ctx, record) {
+			return
+		}
+	}
+}
+
+// dirWatch starts a follower for every new file in a directory whose name matches glob.
+type dirWatch struct {
+	t    *tailer
+	dir  string
+	glob string
+
+	mu   sync.Mutex
+	seen []os.FileInfo // The files that were followed and still exist
+}
+
+func (d *dirWatch) run(ctx context.Context, fromEnd bool, wg *sync.WaitGroup) {
+	for ctx.Err() == nil {
+		changed := d.t.nt.wait()
+		d.scan(ctx, fromEnd, wg)
+		fromEnd = false // only the files that were there at the start
+		select {
+		case <-changed:
+		case <-ctx.Done():
+		}
+	}
+}
+
+func (d *dirWatch) scan(ctx context.Context, fromEnd bool, wg *sync.WaitGroup) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		n.Errorf("%v", err)
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var current []os.FileInfo
+	for _, e := range entries {
+		if ok, _ := filepath.Match(d.glob, e.Name()); !ok {
+			continue
+		}
+		path := filepath.Join(d.dir, e.Name())
+		st, err := os.Stat(path)
+		if err != nil || !st.Mode().IsRegular() {
+			continue
+		}
+		current = append(current, st)
+		if d.isSeen(st) {
+			continue
+		}
+		d.seen = append(d.seen, st)
+		f := &follower{t: d.t, path: path, fromEnd: fromEnd, dir: d}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.run(ctx)
+		}()
+	}
+	// Forget the files that are gone, which ends their followers. The identity of a removed file can be reused.
+	var seen []os.FileInfo
+	for _, st := range d.seen {
+		for _, cur := range current {
+			if os.SameFile(st, cur) {
+				seen = append(seen, st)
+				break
+			}
+		}
+	}
+	d.seen = seen
+}
+
+func (d *dirWatch) isSeen(st os.FileInfo) bool {
+	for _, s := range d.seen {
+		if os.SameFile(s, st) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *dirWatch) gone(st os.FileInfo) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return !d.isSeen(st)
+}
+
+// markSeen records the file a follower opened, which may have been created after the scan that started it.
+func (d *dirWatch) markSeen(st os.FileInfo) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.isSeen(st) {
+		d.seen = append(d.seen, st)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expect reads records from t until it got want or a second passed.
+func expect(t *testing.T, tl *tailer, want ...string) {
+	t.Helper()
+	var got []string
+	timeout := time.After(time.Second)
+	for len(got) < len(want) {
+		select {
+		case record := <-tl.records:
+			got = append(got, string(record))
+		case <-timeout:
+			assert.Equal(t, want, got, "timed out")
+			return
+		}
+	}
+	assert.Equal(t, want, got)
+}
+
+func newTailer() *tailer {
+	return &tailer{nt: newNotifier(), records: make(chan []byte, 64)}
+}
+
+// start runs fn until the test ends, notifying tl every few milliseconds like -poll.
+func start(t *testing.T, tl *tailer, fn func(ctx context.Context, wg *sync.WaitGroup)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		fn(ctx, &wg)
+	}()
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			time.Sleep(5 * time.Millisecond)
+			tl.nt.notify()
+		}
+	}()
+}
+
+func appendFile(t *testing.T, path, data string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+// expectNone checks that no record arrives for a while.
+func expectNone(t *testing.T, tl *tailer) {
+	t.Helper()
+	select {
+	case record := <-tl.records:
+		t.Errorf("unexpected record %q", record)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestFollowFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	tl := newTailer()
+	start(t, tl, func(ctx context.Context, wg *sync.WaitGroup) {
+		(&follower{t: tl, path: path, persistent: true}).run(ctx)
+	})
+
+	appendFile(t, path, "a\nb") // the file does not exist at start
+	expect(t, tl, "a")
+	appendFile(t, path, "\nc\n")
+	expect(t, tl, "b", "c")
+
+	// Rotation: the rest of the old file, then the new one
+	require.NoError(t, os.Rename(path, path+".1"))
+	appendFile(t, path+".1", "d\n")
+	appendFile(t, path, "e\n")
+	expect(t, tl, "d", "e")
+
+	// Truncation, noticed when the file is smaller than what was read or starts differently
+	require.NoError(t, os.Truncate(path, 0))
+	time.Sleep(50 * time.Millisecond)
+	appendFile(t, path, "f\n")
+	expect(t, tl, "f")
+	require.NoError(t, os.Truncate(path, 0))
+	appendFile(t, path, "g\nh\n")
+	expect(t, tl, "g", "h")
+
+	// The incomplete last record of a removed file is dropped
+	appendFile(t, path, "i\npart")
+	require.NoError(t, os.Remove(path))
+	expect(t, tl, "i")
+	appendFile(t, path, "j\n")
+	expect(t, tl, "j")
+	expectNone(t, tl)
+}
+
+func TestFollowFromEnd(t *testing.T) {
+	dir := t.TempDir()
+	existing, missing := filepath.Join(dir, "existing"), filepath.Join(dir, "missing")
+	appendFile(t, existing, "old\n")
+	tl := newTailer()
+	start(t, tl, func(ctx context.Context, wg *sync.WaitGroup) {
+		var followers sync.WaitGroup
+		for _, path := range []string{existing, missing} {
+			followers.Add(1)
+			go func(path string) {
+				defer followers.Done()
+				(&follower{t: tl, path: path, fromEnd: true, persistent: true}).run(ctx)
+			}(path)
+		}
+		followers.Wait()
+	})
+	time.Sleep(50 * time.Millisecond) // let existing be opened at its end
+
+	// A file that only appears later is read from its start
+	appendFile(t, missing, "one\ntwo\n")
+	expect(t, tl, "one", "two")
+	appendFile(t, existing, "new\n")
+	expect(t, tl, "new")
+	expectNone(t, tl)
+}
+
+func TestFollowDir(t *testing.T) {
+	dir := t.TempDir()
+	appendFile(t, filepath.Join(dir, "old.log"), "old\n")
+	appendFile(t, filepath.Join(dir, "skip.txt"), "skip\n")
+	tl := newTailer()
+	d := &dirWatch{t: tl, dir: dir, glob: "*.log"}
+	start(t, tl, func(ctx context.Context, wg *sync.WaitGroup) {
+		d.run(ctx, true, wg)
+	})
+	// Wait for old.log to be opened at its end
+	require.Eventually(t, func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return len(d.seen) == 1
+	}, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	appendFile(t, filepath.Join(dir, "old.log"), "old2\n")
+	expect(t, tl, "old2")
+	appendFile(t, filepath.Join(dir, "new.log"), "new\n")
+	expect(t, tl, "new")
+
+	// A renamed file is followed under its new name and not read again
+	require.NoError(t, os.Rename(filepath.Join(dir, "new.log"), filepath.Join(dir, "renamed.log")))
+	appendFile(t, filepath.Join(dir, "renamed.log"), "renamed\n")
+	expect(t, tl, "renamed")
+	appendFile(t, filepath.Join(dir, "new.log"), "new again\n")
+	expect(t, tl, "new again")
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "context"
+
+// watch does nothing without inotify, changes are only found every -poll.
+func watch(ctx context.Context, dirs []string, nt *notifier) error {
+	return nil
+}
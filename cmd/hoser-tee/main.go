@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/masp/hoser-runtime/node"
+	"github.com/masp/hoser-runtime/plan"
+	"github.com/masp/hoser-runtime/spill"
+)
+
+// hoser-tee copies every record of stdin to stdout and to every output argument, each
+// record with a single write so that outputs shared with other writers never see a
+// partial record. Records are framed according to -framing and -sep like hoser-merge.
+//
+// Every output is written by its own goroutine from a queue, and -policy decides what
+// happens when an output falls behind and its queue is full:
+//
+//	block  wait for the output, so the slowest output sets the pace (default)
+//	drop   drop the record for that output only, the number of dropped records is logged
+//	spill  keep queueing, overflowing to a temporary file once -mem bytes are queued
+//
+// An output that fails (e.g. its reader exited) is reported and skipped from then on;
+// the other outputs keep receiving records.
+
+var (
+	n          = node.New("hoser-tee")
+	framing    = n.FramingFlags()
+	policy     = n.Flags.String("policy", "block", "what to do when an output is slower than stdin: block, drop or spill")
+	queueSize  = n.Flags.Int("queue", 1024, "with -policy block or drop, the records queued for every output")
+	memLimit   = n.Flags.Int64("mem", 64<<20, "with -policy spill, the bytes queued in memory for every output before spilling to disk")
+	spillDir   = n.Flags.String("spill-dir", "", "directory for the spill files (default: the temporary directory)")
+	stdin      = n.Input("stdin", plan.TypeStream, "the records to copy")
+	stdout     = n.Output("stdout", plan.TypeStream, "a copy of the records")
+	outputArgs = n.Outputs("outputs", "more copies of the records")
+)
+
+func main() {
+	log.SetOutput(os.Stderr)
+	log.SetFlags(0)
+	n.Run(run)
+}
+
+func run(ctx context.Context) error {
+	switch *policy {
+	case "block", "drop", "spill":
+	default:
+		return fmt.Errorf("unknown policy '%s'", *policy)
+	}
+	if *queueSize < 1 {
+		return fmt.Errorf("-queue must be at least 1")
+	}
+	// A consumer that exits must only fail its own output instead of killing hoser-tee
+	signal.Ignore(syscall.SIGPIPE)
+
+	in, err := stdin.Open()
+	if err != nil {
+		return err
+	}
+	out, err := stdout.Create()
+	if err != nil {
+		return err
+	}
+	files, err := outputArgs.CreateAll()
+	if err != nil {
+		return err
+	}
+	outputs := []*output{newOutput("stdout", out)}
+	for i, f := range files {
+		outputs = append(outputs, newOutput(outputArgs.Paths()[i], f))
+	}
+
+	var wg sync.WaitGroup
+	for _, o := range outputs {
+		wg.Add(1)
+		go func(o *output) {
+			defer wg.Done()
+			o.run()
+		}(o)
+	}
+
+	rd := (*framing).NewReader(in)
+	var total, totalBytes int64
+	for ctx.Err() == nil {
+		record, err := rd.ReadRecord()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			n.Errorf("read stdin: %v", err)
+			break
+		}
+		record = append([]byte(nil), record...) // shared by every output, which only read it
+		for _, o := range outputs {
+			o.push(record)
+		}
+		total++
+		totalBytes += int64(len(record))
+		n.Progress(total, totalBytes)
+	}
+
+	for _, o := range outputs {
+		o.closeQueue()
+	}
+	wg.Wait()
+	failed := 0
+	for _, o := range outputs {
+		if o.dropped > 0 {
+			n.Logf("output '%s': dropped %d records", o.name, o.dropped)
+		}
+		if o.err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d outputs failed", failed, len(outputs))
+	}
+	return nil
+}
+
+// output is a destination of the records with its own queue.
+type output struct {
+	name string
+	w    io.WriteCloser
+	wr   node.RecordWriter
+
+	queue   chan []byte  // With -policy block and drop
+	spilled *spill.Queue // With -policy spill
+	dropped int64
+	err     error // The first write error, set by run
+}
+
+func newOutput(name string, w io.WriteCloser) *output {
+	o := &output{name: name, w: w, wr: (*framing).NewWriter(w)}
+	if *policy == "spill" {
+		o.spilled = spill.New(*memLimit, *spillDir)
+	} else {
+		o.queue = make(chan []byte, *queueSize)
+	}
+	return o
+}
+
+// push queues a record for the output according to -policy.
+func (o *output) push(record []byte) {
+	switch {
+	case o.spilled != nil:
+		if err := o.spilled.Push(record); err != nil {
+			n.Errorf("output '%s': %v", o.name, err)
+			o.dropped++
+		}
+	case *policy == "drop":
+		select {
+		case o.queue <- record:
+		default:
+			o.dropped++
+		}
+	default:
+		o.queue <- record
+	}
+}
+
+func (o *output) pop() ([]byte, bool) {
+	if o.spilled != nil {
+		record, err := o.spilled.Pop()
+		if err != nil {
+			if err != io.EOF {
+				n.Errorf("output '%s': %v", o.name, err)
+			}
+			return nil, false
+		}
+		return record, true
+	}
+	record, ok := <-o.queue
+	return record, ok
+}
+
+func (o *output) closeQueue() {
+	if o.spilled != nil {
+		o.spilled.Close()
+	} else {
+		close(o.queue)
+	}
+}
+
+// run writes the queued records until the queue is closed. After a write fails, the records are still taken from
+// the queue so that pushing never blocks on a failed output.
+func (o *output) run() {
+	defer func() {
+		if o.spilled != nil {
+			o.spilled.Release()
+		}
+		o.w.Close()
+	}()
+	for {
+		record, ok := o.pop()
+		if !ok {
+			return
+		}
+		if o.err != nil {
+			continue
+		}
+		if err := o.wr.WriteRecord(record); err != nil {
+			o.err = err
+			n.Errorf("output '%s': %v", o.name, err)
+		}
+	}
+}
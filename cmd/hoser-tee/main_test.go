@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowOutput is an output whose writes wait until it is opened.
+type slowOutput struct {
+	open chan struct{}
+	mu   sync.Mutex
+	buf  bytes.Buffer
+}
+
+func newSlowOutput() *slowOutput {
+	return &slowOutput{open: make(chan struct{})}
+}
+
+func (s *slowOutput) Write(p []byte) (int, error) {
+	<-s.open
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *slowOutput) Close() error { return nil }
+
+func (s *slowOutput) records() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return strings.Fields(s.buf.String())
+}
+
+// startOutput starts an output with the policy that writes to a slow output.
+func startOutput(t *testing.T, withPolicy string) (*output, *slowOutput, *sync.WaitGroup) {
+	p, q, mem, dir := *policy, *queueSize, *memLimit, *spillDir
+	t.Cleanup(func() { *policy, *queueSize, *memLimit, *spillDir = p, q, mem, dir })
+	*policy, *queueSize, *memLimit, *spillDir = withPolicy, 1, 8, t.TempDir()
+
+	slow := newSlowOutput()
+	o := newOutput("slow", slow)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		o.run()
+	}()
+	return o, slow, &wg
+}
+
+func records(count int) []string {
+	var recs []string
+	for i := 0; i < count; i++ {
+		recs = append(recs, fmt.Sprintf("rec%d", i))
+	}
+	return recs
+}
+
+// pushAll pushes the records and reports if that finished within a short time.
+func pushAll(o *output, recs []string) bool {
+	pushed := make(chan struct{})
+	go func() {
+		defer close(pushed)
+		for _, rec := range recs {
+			o.push([]byte(rec))
+		}
+	}()
+	select {
+	case <-pushed:
+		return true
+	case <-time.After(100 * time.Millisecond):
+		return false
+	}
+}
+
+func TestPolicyBlock(t *testing.T) {
+	o, slow, wg := startOutput(t, "block")
+	recs := records(10)
+	assert.False(t, pushAll(o, recs), "pushing waits for the slow output")
+	close(slow.open)
+	assert.Eventually(t, func() bool { return len(slow.records()) == len(recs) }, time.Second, time.Millisecond)
+	o.closeQueue()
+	wg.Wait()
+	assert.Equal(t, recs, slow.records())
+	assert.Zero(t, o.dropped)
+}
+
+func TestPolicyDrop(t *testing.T) {
+	o, slow, wg := startOutput(t, "drop")
+	recs := records(10)
+	assert.True(t, pushAll(o, recs), "pushing never waits for the slow output")
+	close(slow.open)
+	o.closeQueue()
+	wg.Wait()
+
+	got := slow.records()
+	assert.LessOrEqual(t, len(got), 2, "one record being written and one queued")
+	assert.Equal(t, int64(len(recs)-len(got)), o.dropped)
+	assert.Subset(t, recs, got)
+}
+
+func TestPolicySpill(t *testing.T) {
+	o, slow, wg := startOutput(t, "spill")
+	recs := records(100)
+	require.True(t, pushAll(o, recs), "pushing never waits for the slow output")
+	_, spilled := o.spilled.Len()
+	assert.NotZero(t, spilled, "the records beyond -mem are spilled")
+	close(slow.open)
+	o.closeQueue()
+	wg.Wait()
+	assert.Equal(t, recs, slow.records(), "every record is written in order")
+	assert.Zero(t, o.dropped)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/masp/hoser-runtime/node"
+	"github.com/masp/hoser-runtime/plan"
+)
+
+// hoser-window groups the records of stdin into batches (see window.go) and writes every
+// batch to stdout as a single record, so that tools that work on batches can be used in
+// a streaming pipe. With -format json a batch is a JSON array of the records (embedded as
+// is with -framing jsonl, as strings otherwise). With -format block it is the records
+// framed like the input, one after the other, e.g. "a\nb\n" for lines.
+//
+// Batches are terminated by -out-sep, except with -framing length where they are length
+// prefixed like the records, so that -format block nests cleanly. Lines batched as
+// blocks can be read back with -sep '\n\n'.
+
+var (
+	n        = node.New("hoser-window")
+	framing  = n.FramingFlags()
+	count    = n.Flags.Int("count", 0, "close a window after this many records")
+	maxBytes = n.Flags.Int64("bytes", 0, "close a window before it exceeds this many bytes (tumbling windows only)")
+	interval = n.Flags.Duration("interval", 0, "close a window after this long")
+	slide    = n.Flags.String("slide", "", "make windows sliding: emit the window every N records (with -count) or every duration (with -interval)")
+	format   = n.Flags.String("format", "json", "how a batch is written: json (an array) or block (the framed records)")
+	outSep   = "\n"
+	stdin    = n.Input("stdin", plan.TypeStream, "the records to batch")
+	stdout   = n.Output("stdout", plan.TypeStream, "one record per batch")
+)
+
+func init() {
+	n.Flags.Func("out-sep", "the separator after every batch, escapes like \\n are allowed (default newline)", func(s string) error {
+		if unquoted, err := strconv.Unquote(`"` + s + `"`); err == nil {
+			s = unquoted
+		}
+		if s == "" {
+			return fmt.Errorf("out-sep cannot be empty")
+		}
+		outSep = s
+		return nil
+	})
+}
+
+func main() {
+	log.SetOutput(os.Stderr)
+	log.SetFlags(0)
+	n.Run(run)
+}
+
+func run(ctx context.Context) error {
+	if *count < 0 || *maxBytes < 0 || *interval < 0 {
+		return fmt.Errorf("-count, -bytes and -interval cannot be negative")
+	}
+	if *count == 0 && *maxBytes == 0 && *interval == 0 {
+		return fmt.Errorf("one of -count, -bytes or -interval is required")
+	}
+	if *format != "json" && *format != "block" {
+		return fmt.Errorf("unknown format '%s'", *format)
+	}
+	in, err := stdin.Open()
+	if err != nil {
+		return err
+	}
+	out, err := stdout.Create()
+	if err != nil {
+		return err
+	}
+	var outFraming node.Framing = node.Delim(outSep)
+	if _, ok := (*framing).(node.LengthPrefixed); ok {
+		outFraming = node.LengthPrefixed{}
+	}
+	e := &emitter{wr: outFraming.NewWriter(out)}
+
+	records := make(chan []byte)
+	go func() {
+		defer close(records)
+		rd := (*framing).NewReader(in)
+		for {
+			record, err := rd.ReadRecord()
+			if err != nil {
+				if err != io.EOF {
+					n.Errorf("read stdin: %v", err)
+				}
+				return
+			}
+			select {
+			case records <- append([]byte(nil), record...):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	if *slide == "" {
+		return tumble(ctx, records, e)
+	}
+	if every, err := strconv.Atoi(*slide); err == nil {
+		if *count == 0 || *maxBytes > 0 || *interval > 0 || every < 1 {
+			return fmt.Errorf("-slide N needs -count (and no -bytes or -interval), N must be at least 1")
+		}
+		return slideCount(ctx, records, e, &slidingCount{size: *count, every: every})
+	}
+	every, err := time.ParseDuration(*slide)
+	if err != nil || every <= 0 {
+		return fmt.Errorf("-slide must be a number of records or a positive duration")
+	}
+	if *interval == 0 || *count > 0 || *maxBytes > 0 {
+		return fmt.Errorf("-slide duration needs -interval (and no -count or -bytes)")
+	}
+	return slideTime(ctx, records, e, &slidingTime{interval: *interval}, every)
+}
+
+func tumble(ctx context.Context, records <-chan []byte, e *emitter) error {
+	w := &tumbling{count: *count, bytes: *maxBytes}
+	var timer *time.Timer
+	var expired <-chan time.Time
+	for {
+		select {
+		case record, ok := <-records:
+			if !ok {
+				return e.emit(w.flush())
+			}
+			opened := len(w.records) == 0
+			batches := w.add(record)
+			for _, batch := range batches {
+				if err := e.emit(batch); err != nil {
+					return err
+				}
+			}
+			if *interval == 0 {
+				continue
+			}
+			// The window opens with its first record, which may be the one that closed the last window
+			if len(batches) > 0 || opened {
+				if timer != nil {
+					timer.Stop()
+				}
+				expired = nil
+				if len(w.records) > 0 {
+					timer = time.NewTimer(*interval)
+					expired = timer.C
+				}
+			}
+		case <-expired:
+			expired = nil
+			if err := e.emit(w.flush()); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return e.emit(w.flush())
+		}
+	}
+}
+
+func slideCount(ctx context.Context, records <-chan []byte, e *emitter, w *slidingCount) error {
+	for {
+		select {
+		case record, ok := <-records:
+			if !ok {
+				return e.emit(w.flush())
+			}
+			for _, batch := range w.add(record) {
+				if err := e.emit(batch); err != nil {
+					return err
+				}
+			}
+		case <-ctx.Done():
+			return e.emit(w.flush())
+		}
+	}
+}
+
+func slideTime(ctx context.Context, records <-chan []byte, e *emitter, w *slidingTime, every time.Duration) error {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case record, ok := <-records:
+			if !ok {
+				return e.emit(w.window(time.Now()))
+			}
+			w.add(record, time.Now())
+		case now := <-ticker.C:
+			if err := e.emit(w.window(now)); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return e.emit(w.window(time.Now()))
+		}
+	}
+}
+
+// emitter writes batches in the chosen format.
+type emitter struct {
+	wr         node.RecordWriter
+	buf        bytes.Buffer
+	records    int64
+	totalBytes int64
+}
+
+// emit writes a batch, empty batches are skipped.
+func (e *emitter) emit(batch [][]byte) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	e.buf.Reset()
+	if *format == "json" {
+		values := make([]any, len(batch))
+		_, raw := (*framing).(node.JSONLines)
+		for i, record := range batch {
+			if raw {
+				values[i] = json.RawMessage(record)
+			} else {
+				values[i] = string(record)
+			}
+		}
+		if err := json.NewEncoder(&e.buf).Encode(values); err != nil {
+			return err
+		}
+		e.buf.Truncate(e.buf.Len() - 1) // the newline of Encode
+	} else {
+		wr := (*framing).NewWriter(&e.buf)
+		for _, record := range batch {
+			if err := wr.WriteRecord(record); err != nil {
+				return err
+			}
+		}
+	}
+	if err := e.wr.WriteRecord(e.buf.Bytes()); err != nil {
+		return fmt.Errorf("write stdout: %w", err)
+	}
+	e.records += int64(len(batch))
+	e.totalBytes += int64(e.buf.Len())
+	n.Progress(e.records, e.totalBytes)
+	return nil
+}
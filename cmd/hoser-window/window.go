@@ -0,0 +1,102 @@
+package main
+
+import (
+	"time"
+)
+
+// Windows are either tumbling, where every record is in exactly one batch, or sliding, where a batch of the most
+// recent records is emitted every -slide records (or every -slide duration) and consecutive batches overlap.
+//
+// A tumbling window is closed by whichever limit is reached first: -count records, -bytes bytes (the record that
+// would exceed the limit starts the next batch) or -interval since the window was opened. A sliding window has
+// either a -count or an -interval size.
+
+// tumbling collects records until a limit is reached.
+type tumbling struct {
+	count int   // 0 is no limit
+	bytes int64 // 0 is no limit
+
+	records [][]byte
+	size    int64
+}
+
+// add adds a record and returns the batches it closed, if any.
+func (w *tumbling) add(record []byte) [][][]byte {
+	var batches [][][]byte
+	if w.bytes > 0 && len(w.records) > 0 && w.size+int64(len(record)) > w.bytes {
+		batches = append(batches, w.flush())
+	}
+	w.records = append(w.records, record)
+	w.size += int64(len(record))
+	if w.count > 0 && len(w.records) >= w.count || w.bytes > 0 && w.size >= w.bytes {
+		batches = append(batches, w.flush())
+	}
+	return batches
+}
+
+// flush closes the window, returning its records (nil if it is empty).
+func (w *tumbling) flush() [][]byte {
+	batch := w.records
+	w.records, w.size = nil, 0
+	return batch
+}
+
+// slidingCount keeps the last size records and emits them every every records.
+type slidingCount struct {
+	size, every int
+
+	records [][]byte
+	pending int // Records added since the last batch
+}
+
+func (w *slidingCount) add(record []byte) [][][]byte {
+	w.records = append(w.records, record)
+	if len(w.records) > w.size {
+		w.records[0] = nil
+		w.records = w.records[1:]
+	}
+	w.pending++
+	if w.pending < w.every {
+		return nil
+	}
+	return [][][]byte{w.flush()}
+}
+
+// flush returns the current window if it has records that were not emitted yet.
+func (w *slidingCount) flush() [][]byte {
+	if w.pending == 0 {
+		return nil
+	}
+	w.pending = 0
+	return append([][]byte(nil), w.records...)
+}
+
+// slidingTime keeps the records of the last interval. The batches are emitted by a ticker, see window.
+type slidingTime struct {
+	interval time.Duration
+
+	records [][]byte
+	times   []time.Time
+	pending int
+}
+
+func (w *slidingTime) add(record []byte, now time.Time) {
+	w.records = append(w.records, record)
+	w.times = append(w.times, now)
+	w.pending++
+}
+
+// window returns the records added in the interval before now, nil if there are none or none of them are new
+// since the last window.
+func (w *slidingTime) window(now time.Time) [][]byte {
+	i := 0
+	for i < len(w.times) && !w.times[i].After(now.Add(-w.interval)) {
+		i++
+	}
+	w.records, w.times = w.records[i:], w.times[i:]
+	if w.pending == 0 || len(w.records) == 0 {
+		return nil
+	}
+	w.pending = 0
+	return append([][]byte(nil), w.records...)
+}
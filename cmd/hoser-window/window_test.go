@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func join(batches ...[][]byte) []string {
+	var got []string
+	for _, batch := range batches {
+		var recs []string
+		for _, rec := range batch {
+			recs = append(recs, string(rec))
+		}
+		got = append(got, strings.Join(recs, ","))
+	}
+	return got
+}
+
+func addAll(add func([]byte) [][][]byte, records ...string) [][][]byte {
+	var batches [][][]byte
+	for _, rec := range records {
+		batches = append(batches, add([]byte(rec))...)
+	}
+	return batches
+}
+
+func TestTumbling(t *testing.T) {
+	w := &tumbling{count: 2}
+	assert.Equal(t, []string{"a,b", "c,d"}, join(addAll(w.add, "a", "b", "c", "d", "e")...))
+	assert.Equal(t, []string{"e"}, join(w.flush()))
+
+	w = &tumbling{bytes: 4}
+	assert.Equal(t, []string{"aa,b", "ccc"}, join(addAll(w.add, "aa", "b", "ccc", "dd")...))
+	assert.Equal(t, []string{"dd"}, join(w.flush()))
+	assert.Nil(t, w.flush())
+}
+
+func TestSliding(t *testing.T) {
+	w := &slidingCount{size: 3, every: 2}
+	assert.Equal(t, []string{"a,b", "b,c,d"}, join(addAll(w.add, "a", "b", "c", "d", "e")...))
+	assert.Equal(t, []string{"c,d,e"}, join(w.flush()))
+	assert.Nil(t, w.flush())
+
+	start := time.Now()
+	tw := &slidingTime{interval: 10 * time.Second}
+	tw.add([]byte("a"), start)
+	tw.add([]byte("b"), start.Add(5*time.Second))
+	assert.Equal(t, []string{"a,b"}, join(tw.window(start.Add(6*time.Second))))
+	assert.Nil(t, tw.window(start.Add(7*time.Second)), "nothing new")
+	tw.add([]byte("c"), start.Add(12*time.Second))
+	assert.Equal(t, []string{"b,c"}, join(tw.window(start.Add(12*time.Second))))
+}
+
+// batchWriter sends every batch written by an emitter to a channel.
+type batchWriter chan string
+
+func (w batchWriter) WriteRecord(record []byte) error {
+	w <- string(record)
+	return nil
+}
+
+// TestTumbleBytesInterval checks that a window opened by the record that closed the last one at -bytes gets a full
+// -interval.
+func TestTumbleBytesInterval(t *testing.T) {
+	defer func(b int64, i time.Duration) { *maxBytes, *interval = b, i }(*maxBytes, *interval)
+	*maxBytes, *interval = 4, 200*time.Millisecond
+	records, out := make(chan []byte), make(batchWriter, 10)
+	done := make(chan error, 1)
+	go func() { done <- tumble(context.Background(), records, &emitter{wr: out}) }()
+
+	records <- []byte("aaa")
+	time.Sleep(100 * time.Millisecond)
+	records <- []byte("bb")
+	assert.Equal(t, `["aaa"]`, <-out)
+	select {
+	case batch := <-out:
+		t.Fatalf("%s was emitted by the interval of the last window", batch)
+	case <-time.After(150 * time.Millisecond):
+	}
+	select {
+	case batch := <-out:
+		assert.Equal(t, `["bb"]`, batch)
+	case <-time.After(time.Second):
+		t.Fatal("the window was never closed by -interval")
+	}
+	close(records)
+	assert.NoError(t, <-done)
+}
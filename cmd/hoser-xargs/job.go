@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// job is a single invocation of the command, which may take several attempts.
+type job struct {
+	seq   int
+	dir   string
+	input []string // The input lines of the job
+	args  []string // The command line
+	stdin []byte   // With -stdin, the input lines fed to the command
+
+	paths   *pathWriter
+	outputs []*output
+	written bool // The paths of the outputs were written
+}
+
+// output is where a single stream (stdout or stderr) of a job goes.
+type output struct {
+	path string
+	file *os.File
+}
+
+func (j *job) run(ctx context.Context, jobs *jobLog) error {
+	defer j.close()
+	if err := j.open(ctx); err != nil {
+		return err
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = j.attempt(ctx, attempt, jobs)
+		if err == nil || attempt > *retries || ctx.Err() != nil {
+			break
+		}
+		delay := *backoff << (attempt - 1)
+		n.Errorf("[job %d] attempt %d %v, retrying in %v", j.seq, attempt, err, delay)
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+		if *spool {
+			for _, out := range j.outputs {
+				if terr := out.file.Truncate(0); terr != nil {
+					return terr
+				}
+				out.file.Seek(0, io.SeekStart)
+			}
+		}
+	}
+	return err
+}
+
+// open creates the outputs of the job. FIFOs are written to stdout and opened once the consumer opens them.
+func (j *job) open(ctx context.Context) error {
+	names := []string{"out"}
+	if *withStderr {
+		names = append(names, "err")
+	}
+	if *spool {
+		for _, name := range names {
+			// A unique name, so the outputs of an earlier run into the same directory are kept
+			f, err := os.CreateTemp(j.dir, fmt.Sprintf("%d.*.%s", j.seq, name))
+			if err != nil {
+				for _, out := range j.outputs {
+					out.file.Close()
+					os.Remove(out.path)
+				}
+				j.outputs = nil
+				return err
+			}
+			j.outputs = append(j.outputs, &output{path: f.Name(), file: f})
+		}
+		return nil
+	}
+
+	for _, name := range names {
+		j.outputs = append(j.outputs, &output{path: filepath.Join(j.dir, fmt.Sprintf("%d.%s", j.seq, name))})
+	}
+	for _, out := range j.outputs {
+		if err := syscall.Mkfifo(out.path, 0o600); err != nil {
+			return fmt.Errorf("mkfifo: %w", err)
+		}
+	}
+	if err := j.writePaths(); err != nil {
+		return err
+	}
+	// Open the FIFOs in the same order they were written, since the consumer opens them in that order
+	for _, out := range j.outputs {
+		f, err := openFifo(ctx, out.path)
+		if err != nil {
+			return err
+		}
+		out.file = f
+		os.Remove(out.path)
+	}
+	return nil
+}
+
+// close closes the outputs. Spool files are written to stdout now that they are complete. Every job writes its
+// paths exactly once, with no paths if the outputs could not be created, so that -k can advance.
+func (j *job) close() {
+	complete := true
+	for _, out := range j.outputs {
+		if out.file == nil {
+			complete = false
+			continue
+		}
+		out.file.Close()
+	}
+	if !j.written {
+		if !complete {
+			j.outputs = nil
+		}
+		if err := j.writePaths(); err != nil {
+			n.Errorf("[job %d] write paths: %v", j.seq, err)
+		}
+	}
+}
+
+func (j *job) writePaths() error {
+	j.written = true
+	paths := make([]string, len(j.outputs))
+	for i, out := range j.outputs {
+		paths[i] = out.path
+	}
+	return j.paths.write(j.seq, paths...)
+}
+
+// setOutputs connects the stdout and stderr of cmd to the outputs of the job.
+func (j *job) setOutputs(cmd *exec.Cmd) {
+	cmd.Stdout = j.outputs[0].file
+	if *withStderr {
+		cmd.Stderr = j.outputs[1].file
+	} else {
+		cmd.Stderr = os.Stderr
+	}
+}
+
+// attempt runs the command once and records the attempt in the job log.
+func (j *job) attempt(ctx context.Context, attempt int, jobs *jobLog) error {
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, j.args[0], j.args[1:]...)
+	j.setOutputs(cmd)
+	if j.stdin != nil {
+		cmd.Stdin = bytes.NewReader(j.stdin)
+	}
+
+	start := time.Now()
+	err := cmd.Run()
+	entry := jobEntry{
+		Seq:      j.seq,
+		Input:    j.input,
+		Attempt:  attempt,
+		Exit:     -1,
+		Start:    start,
+		Duration: time.Since(start).Seconds(),
+	}
+	if cmd.ProcessState != nil {
+		entry.Exit = cmd.ProcessState.ExitCode()
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %v", *timeout)
+	} else if err != nil {
+		err = fmt.Errorf("exited: %w", err)
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if lerr := jobs.record(entry); lerr != nil {
+		n.Errorf("write job log: %v", lerr)
+	}
+	return err
+}
+
+// fifoPollInterval is how often openFifo checks if the consumer opened the FIFO.
+const fifoPollInterval = 10 * time.Millisecond
+
+// openFifo opens a FIFO for writing once a consumer has opened it for reading. Unlike a blocking open, it gives up
+// when ctx is cancelled.
+func openFifo(ctx context.Context, path string) (*os.File, error) {
+	for {
+		fd, err := syscall.Open(path, syscall.O_WRONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+		if err == nil {
+			if err := syscall.SetNonblock(fd, false); err != nil {
+				syscall.Close(fd)
+				return nil, err
+			}
+			return os.NewFile(uintptr(fd), path), nil
+		}
+		if err != syscall.ENXIO { // ENXIO means there is no reader yet
+			return nil, &os.PathError{Op: "open", Path: path, Err: err}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(fifoPollInterval):
+		}
+	}
+}
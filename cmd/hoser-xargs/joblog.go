@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jobEntry is a single line of the job log, written after every attempt of a job.
+type jobEntry struct {
+	Seq      int       `json:"seq"`
+	Input    []string  `json:"input"`
+	Attempt  int       `json:"attempt"`
+	Exit     int       `json:"exit"` // -1 if the process was killed or could not be started
+	Start    time.Time `json:"start"`
+	Duration float64   `json:"duration"` // in seconds
+	Error    string    `json:"error,omitempty"`
+}
+
+// jobLog records every attempt of every job as JSON lines, so that a later run can skip the inputs that already
+// succeeded with -resume.
+type jobLog struct {
+	mu   sync.Mutex
+	f    *os.File
+	enc  *json.Encoder
+	last int // The highest seq of the earlier runs, the jobs of this run are numbered after it
+}
+
+// openJobLog opens the job log for appending. It returns the inputs that completed successfully in earlier runs.
+// The jobs of every run get their own seq, so the outputs of a resumed run don't replace those of earlier runs.
+func openJobLog(path string) (*jobLog, map[string]bool, error) {
+	done := make(map[string]bool)
+	last := 0
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rd := bufio.NewReader(f)
+	for {
+		line, err := rd.ReadBytes('\n')
+		if len(line) > 0 {
+			var entry jobEntry
+			// A partial line is left by an interrupted run, ignore it
+			if json.Unmarshal(line, &entry) == nil {
+				if entry.Exit == 0 && entry.Error == "" {
+					done[inputKey(entry.Input)] = true
+				}
+				if entry.Seq > last {
+					last = entry.Seq
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+	}
+	return &jobLog{f: f, enc: json.NewEncoder(f), last: last}, done, nil
+}
+
+func (l *jobLog) record(entry jobEntry) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enc.Encode(entry)
+}
+
+func (l *jobLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+// inputKey identifies the input of a job in the job log.
+func inputKey(input []string) string {
+	return strings.Join(input, "\x00")
+}
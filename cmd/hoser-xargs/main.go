@@ -2,65 +2,288 @@ package main
 
 import (
 	"bufio"
-	"flag"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
 	"log"
 	"os"
-	"os/exec"
-	"strings"
+	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/masp/hoser-runtime/node"
+	"github.com/masp/hoser-runtime/plan"
 )
 
 // hoser-xargs takes in a stream of arguments and creates a process for each line
-// passing that line as an argument. The stdout and stderr of each process is written
-// as filenames to stdout. If any errors occur, they are written to stderr.
-
-const MaxLineSize = 5024
+// (or with -n, each batch of lines) passing the lines as arguments. At most -P processes
+// run at the same time. The lines can be split into fields (see template.go) which are
+// substituted into the arguments with placeholders like {1}, {name} or {/.}. The stdout
+// (and with -stderr, the stderr) of each process is exposed as a path which is written to
+// stdout, one per line, so that hoser-merge can read the outputs of all the processes. If
+// any errors occur, they are written to stderr.
+//
+// By default each output is a named FIFO whose path is written as soon as the process
+// starts, so the output is streamed. With -spool the output is written to a file in -dir
+// instead and its path is written once the process exits. The files are read after
+// hoser-xargs may have exited, so removing them is up to the caller. With -k the paths
+// are written in the order of the input lines.
+//
+// Failed jobs are retried up to -retries times with exponential backoff, and every
+// attempt can be recorded in a -joblog. With -resume, the inputs that already succeeded
+// according to the job log are skipped.
+//
+// With -stdin, the lines are fed to the stdin of the process instead of passed as
+// arguments. With -workers N, N long-lived processes are started up front and the lines
+// are fed round-robin to their stdin, avoiding a fork per line.
 
 var (
-	replacementToken = flag.String("I", "{}", "replacement token (token will be replaced with line in stdin)")
+	n                = node.New("hoser-xargs")
+	replacementToken = n.Flags.String("I", "{}", "replacement token (token will be replaced with line in stdin)")
+	fieldDelim       = n.Flags.String("d", "", "split each line into fields by this delimiter (default: whitespace)")
+	csvFields        = n.Flags.Bool("csv", false, "split each line into fields as CSV")
+	jsonFields       = n.Flags.Bool("json", false, "each line is a JSON object, fields are referenced by key ({a.b} for nested keys)")
+	header           = n.Flags.Bool("header", false, "the first line names the fields, so they can be referenced as {name}")
+	spool            = n.Flags.Bool("spool", false, "write each output to a file and print its path when the process exits instead of streaming through a FIFO")
+	withStderr       = n.Flags.Bool("stderr", false, "also expose the stderr of each process, printed on the line after its stdout (default: inherit stderr)")
+	outDir           = n.Flags.String("dir", "", "directory for the FIFOs and spool files, required with -spool (default: a new temporary directory)")
+	parallel         = n.Flags.Int("P", runtime.NumCPU(), "maximum number of processes running at the same time")
+	batchSize        = n.Flags.Int("n", 1, "number of input lines passed to each process")
+	keepOrder        = n.Flags.Bool("k", false, "write the output paths in the order of the input lines")
+	nulInput         = n.Flags.Bool("0", false, "input lines are terminated by NUL instead of newline")
+	retries          = n.Flags.Int("retries", 0, "number of times a failed job is retried (FIFO outputs keep what earlier attempts wrote)")
+	backoff          = n.Flags.Duration("backoff", time.Second, "delay before the first retry, doubled on every retry")
+	timeout          = n.Flags.Duration("timeout", 0, "kill a job attempt that runs longer than this (0 is no timeout)")
+	jobLogPath       = n.Flags.String("joblog", "", "append every job attempt to this file as JSON lines")
+	resume           = n.Flags.Bool("resume", false, "skip the inputs that already succeeded according to -joblog")
+	stdinMode        = n.Flags.Bool("stdin", false, "feed the lines of each job to the stdin of its process instead of passing them as arguments")
+	workers          = n.Flags.Int("workers", 0, "start this many long-lived processes and feed them the lines round-robin over stdin")
+	_                = n.Input("stdin", plan.TypeStream, "the arguments, one per line")
+	stdout           = n.Output("stdout", plan.TypeStream, "the paths of the output streams of each process, one per line")
 )
 
 func main() {
 	log.SetOutput(os.Stderr)
+	log.SetFlags(0)
+	n.Run(run)
+}
 
-	cmdArgs := flag.Args()
+func run(ctx context.Context) error {
+	cmdArgs := n.Args()
+	if len(cmdArgs) == 0 {
+		return fmt.Errorf("no command given")
+	}
+	if *parallel < 1 || *batchSize < 1 {
+		return fmt.Errorf("-P and -n must be at least 1")
+	}
+	if *resume && *jobLogPath == "" {
+		return fmt.Errorf("-resume requires -joblog")
+	}
+	if *spool && *outDir == "" {
+		return fmt.Errorf("-spool requires -dir")
+	}
+	split := &splitter{delim: *fieldDelim}
+	switch {
+	case *jsonFields:
+		split.mode = "json"
+		if *header {
+			return fmt.Errorf("-header cannot be used with -json")
+		}
+	case *csvFields:
+		split.mode = "csv"
+	case *fieldDelim != "":
+		split.mode = "delim"
+	}
+	templates := make([]template, len(cmdArgs))
+	for i, arg := range cmdArgs {
+		templates[i] = compileTemplate(arg, *replacementToken, *header || *jsonFields)
+		if *workers > 0 && templates[i].hasPlaceholder() {
+			return fmt.Errorf("-workers processes are shared by all lines, the command cannot have placeholders")
+		}
+	}
+	if *workers > 0 && (*retries > 0 || *timeout > 0 || *jobLogPath != "") {
+		return fmt.Errorf("-retries, -timeout and -joblog cannot be used with -workers")
+	}
 
-	buf := bufio.NewReaderSize(os.Stdin, MaxLineSize)
-	var wg sync.WaitGroup
-	defer wg.Wait()
-	for {
-		newInput, err := buf.ReadString('\n')
+	var jobs *jobLog
+	completed := make(map[string]bool)
+	if *jobLogPath != "" {
+		var err error
+		jobs, completed, err = openJobLog(*jobLogPath)
 		if err != nil {
-			log.Printf("stdin closed: %v", err)
-			return
+			return fmt.Errorf("open job log: %w", err)
+		}
+		defer jobs.Close()
+		if !*resume {
+			completed = nil
+		}
+	}
+
+	dir := *outDir
+	if dir == "" {
+		var err error
+		dir, err = os.MkdirTemp("", "hoser-xargs-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir) // FIFOs are only needed until they are opened
+	}
+	out, err := stdout.Create()
+	if err != nil {
+		return err
+	}
+	firstSeq := 1
+	if jobs != nil {
+		firstSeq = jobs.last + 1
+	}
+	paths := &pathWriter{w: bufio.NewWriter(out), ordered: *keepOrder, next: firstSeq, pending: make(map[int][]string)}
+
+	var framing node.Framing = node.Delim("\n")
+	if *nulInput {
+		framing = node.Delim("\x00")
+	}
+	input := framing.NewReader(os.Stdin)
+	if *header {
+		names, err := readBatch(input, 1)
+		if err != nil || len(names) == 0 {
+			return fmt.Errorf("read header: %v", err)
+		}
+		rec, err := split.split(names[0])
+		if err != nil {
+			return fmt.Errorf("read header: %w", err)
+		}
+		split.header = rec.fields
+	}
+	if *workers > 0 {
+		return runWorkers(ctx, input, framing, cmdArgs, dir, paths)
+	}
+
+	slots := make(chan struct{}, *parallel)
+	var (
+		wg     sync.WaitGroup
+		failed int32
+	)
+	for seq := firstSeq; ; {
+		batch, err := readBatch(input, *batchSize)
+		if err != nil {
+			n.Errorf("read stdin: %v", err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		if completed[inputKey(batch)] {
+			continue
+		}
+		args, err := batchArgs(split, templates, batch)
+		if err != nil {
+			n.Errorf("skipping %q: %v", batch, err)
+			atomic.AddInt32(&failed, 1)
+			continue
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
 		}
-		newInput = strings.TrimSuffix(newInput, "\n")
 		wg.Add(1)
-		line := 1
-		go func() {
+		go func(j *job) {
 			defer wg.Done()
-			cmd := execLine(cmdArgs, newInput)
-			_, stdoutW, err := os.Pipe()
-			if err != nil {
-				log.Printf("[line %d]: could not create pipe for stdout", line)
-			}
-			_, stderrW, err := os.Pipe()
-			if err != nil {
-				log.Printf("[line %d]: could not create pipe for stderr", line)
+			defer func() { <-slots }()
+			if err := j.run(ctx, jobs); err != nil {
+				n.Errorf("[job %d] %v", j.seq, err)
+				atomic.AddInt32(&failed, 1)
 			}
-			cmd.Stdout = stdoutW
-			cmd.Stderr = stderrW
-			err = cmd.Run()
-			if err != nil {
-				log.Printf("[line %d] pid %d exited: %v", line, cmd.Process.Pid, err)
-			}
-		}()
+		}(&job{seq: seq, dir: dir, input: batch, args: args, stdin: stdinRecords(framing, batch), paths: paths})
+		seq++
+	}
+	wg.Wait()
+	if failed > 0 {
+		return fmt.Errorf("%d jobs failed", failed)
+	}
+	return nil
+}
+
+func batchArgs(split *splitter, templates []template, batch []string) ([]string, error) {
+	recs := make([]record, len(batch))
+	for i, line := range batch {
+		var err error
+		recs[i], err = split.split(line)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buildArgs(templates, recs)
+}
+
+// stdinRecords frames the lines for the stdin of a job with -stdin.
+func stdinRecords(framing node.Framing, batch []string) []byte {
+	if !*stdinMode {
+		return nil
+	}
+	var buf bytes.Buffer
+	wr := framing.NewWriter(&buf)
+	for _, line := range batch {
+		wr.WriteRecord([]byte(line))
+	}
+	return buf.Bytes()
+}
+
+// readBatch reads up to size records. It only returns an error if reading failed before the end of input.
+func readBatch(input node.RecordReader, size int) ([]string, error) {
+	var batch []string
+	for len(batch) < size {
+		record, err := input.ReadRecord()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return batch, err
+		}
+		batch = append(batch, string(record))
+	}
+	return batch, nil
+}
+
+// pathWriter writes the paths of the outputs to stdout. The paths of a single job are always written together.
+// Every job must call write exactly once, with no paths if it has no output, so that ordered writes can advance.
+type pathWriter struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+
+	ordered bool             // Write the paths of the jobs in the order of their seq
+	next    int              // The seq of the next job to write when ordered
+	pending map[int][]string // Paths of jobs that finished before next when ordered
+}
+
+func (pw *pathWriter) write(seq int, paths ...string) error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if !pw.ordered {
+		return pw.flush(paths)
+	}
+
+	pw.pending[seq] = paths
+	for {
+		paths, ok := pw.pending[pw.next]
+		if !ok {
+			return nil
+		}
+		delete(pw.pending, pw.next)
+		pw.next++
+		if err := pw.flush(paths); err != nil {
+			return err
+		}
 	}
 }
 
-func execLine(cmdArgs []string, replacement string) *exec.Cmd {
-	for i := range cmdArgs {
-		cmdArgs[i] = strings.ReplaceAll(cmdArgs[i], *replacementToken, replacement)
+func (pw *pathWriter) flush(paths []string) error {
+	for _, path := range paths {
+		pw.w.WriteString(path)
+		pw.w.WriteByte('\n')
 	}
-	return exec.Command(cmdArgs[0], cmdArgs[1:]...)
+	return pw.w.Flush()
 }
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain runs the test binary as hoser-xargs when XARGS_TEST_RUN is set, so the tests can drive it end to end.
+func TestMain(m *testing.M) {
+	if os.Getenv("XARGS_TEST_RUN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// xargsResult is what a run of hoser-xargs wrote: the output paths in order and what could be read from each.
+type xargsResult struct {
+	paths   []string
+	outputs map[string]string
+	err     error
+}
+
+// sorted returns the outputs sorted, for jobs that run in any order.
+func (r xargsResult) sorted() []string {
+	var outs []string
+	for _, path := range r.paths {
+		outs = append(outs, r.outputs[path])
+	}
+	sort.Strings(outs)
+	return outs
+}
+
+// ordered returns the outputs in the order their paths were written.
+func (r xargsResult) ordered() []string {
+	var outs []string
+	for _, path := range r.paths {
+		outs = append(outs, r.outputs[path])
+	}
+	return outs
+}
+
+// xargs runs hoser-xargs with the input and reads every output as soon as its path is written, like hoser-merge.
+func xargs(t *testing.T, input string, args ...string) xargsResult {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "XARGS_TEST_RUN=1")
+	cmd.Dir = t.TempDir()
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+
+	res := xargsResult{outputs: make(map[string]string)}
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		path := scanner.Text()
+		res.paths = append(res.paths, path)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f, err := os.Open(path)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer f.Close()
+			var buf bytes.Buffer
+			io.Copy(&buf, f)
+			mu.Lock()
+			res.outputs[path] = buf.String()
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	res.err = cmd.Wait()
+	return res
+}
+
+func TestFifoOutput(t *testing.T) {
+	res := xargs(t, "a\nb\nc\n", "-stderr", "sh", "-c", "echo out {}; echo err {} >&2")
+	require.NoError(t, res.err)
+	require.Len(t, res.paths, 6)
+	for i := 0; i < len(res.paths); i += 2 {
+		assert.True(t, strings.HasSuffix(res.paths[i], ".out"), res.paths[i])
+		assert.True(t, strings.HasSuffix(res.paths[i+1], ".err"), res.paths[i+1])
+		out := strings.TrimPrefix(res.outputs[res.paths[i]], "out ")
+		assert.Equal(t, "err "+out, res.outputs[res.paths[i+1]])
+	}
+	assert.NoDirExists(t, filepath.Dir(res.paths[0]), "the FIFOs are removed")
+}
+
+// spooled returns the output of job seq spooled into dir.
+func spooled(t *testing.T, dir, seq string) []string {
+	paths, err := filepath.Glob(filepath.Join(dir, seq+".*.out"))
+	require.NoError(t, err)
+	return paths
+}
+
+func TestSpoolOutput(t *testing.T) {
+	dir := t.TempDir()
+	res := xargs(t, "a\nb\n", "-spool", "-dir", dir, "echo", "{}")
+	require.NoError(t, res.err)
+	assert.ElementsMatch(t, append(spooled(t, dir, "1"), spooled(t, dir, "2")...), res.paths)
+	assert.Equal(t, []string{"a\n", "b\n"}, res.sorted())
+
+	first := res.paths
+	res = xargs(t, "c\n", "-spool", "-dir", dir, "echo", "{}")
+	require.NoError(t, res.err, "a rerun into the same directory succeeds")
+	require.Len(t, res.paths, 1)
+	assert.NotContains(t, first, res.paths[0])
+	assert.Equal(t, []string{"c\n"}, res.sorted())
+	for _, path := range first {
+		got, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Contains(t, []string{"a\n", "b\n"}, string(got), "the outputs of the earlier run are kept")
+	}
+
+	res = xargs(t, "a\n", "-spool", "echo", "{}")
+	assert.Error(t, res.err, "-spool needs a -dir")
+}
+
+func TestParallel(t *testing.T) {
+	// Every job appends to the log when it starts and ends, so with -P 1 the starts and ends alternate
+	log := filepath.Join(t.TempDir(), "log")
+	res := xargs(t, "a\nb\nc\n", "-P", "1", "sh", "-c", "echo start >> "+log+"; sleep 0.05; echo end >> "+log)
+	require.NoError(t, res.err)
+	got, err := os.ReadFile(log)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("start\nend\n", 3), string(got))
+
+	res = xargs(t, "a\n", "-P", "0", "echo")
+	assert.Error(t, res.err)
+}
+
+func TestBatch(t *testing.T) {
+	res := xargs(t, "a\nb\nc\n", "-n", "2", "echo")
+	require.NoError(t, res.err)
+	assert.Equal(t, []string{"a b\n", "c\n"}, res.sorted())
+
+	res = xargs(t, "a b\x00c\x00", "-0", "-n", "2", "printf", "[%s]", "{}")
+	require.NoError(t, res.err)
+	assert.Equal(t, []string{"[a b][c]"}, res.sorted())
+}
+
+func TestKeepOrder(t *testing.T) {
+	// The first jobs take the longest, so they finish last
+	res := xargs(t, "0.3\n0.2\n0.1\n0\n", "-k", "-P", "4", "-spool", "-dir", t.TempDir(), "sh", "-c", "sleep {}; echo {}")
+	require.NoError(t, res.err)
+	assert.Equal(t, []string{"0.3\n", "0.2\n", "0.1\n", "0\n"}, res.ordered())
+}
+
+func readJobLog(t *testing.T, path string) []jobEntry {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	var entries []jobEntry
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var entry jobEntry
+		require.NoError(t, dec.Decode(&entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestRetries(t *testing.T) {
+	dir := t.TempDir()
+	jobLog := filepath.Join(dir, "jobs")
+	// Fails the first time it runs for an input
+	script := "if [ -e {} ]; then echo ok; else touch {}; exit 3; fi"
+	res := xargs(t, filepath.Join(dir, "a")+"\n", "-retries", "1", "-backoff", "1ms", "-joblog", jobLog, "sh", "-c", script)
+	require.NoError(t, res.err)
+	assert.Equal(t, []string{"ok\n"}, res.sorted())
+	entries := readJobLog(t, jobLog)
+	require.Len(t, entries, 2)
+	assert.Equal(t, []int{1, 2}, []int{entries[0].Attempt, entries[1].Attempt})
+	assert.Equal(t, []int{3, 0}, []int{entries[0].Exit, entries[1].Exit})
+	assert.NotEmpty(t, entries[0].Error)
+	assert.Empty(t, entries[1].Error)
+}
+
+func TestTimeout(t *testing.T) {
+	jobLog := filepath.Join(t.TempDir(), "jobs")
+	start := time.Now()
+	res := xargs(t, "5\n", "-timeout", "50ms", "-joblog", jobLog, "sleep")
+	assert.Error(t, res.err)
+	assert.Less(t, time.Since(start), 4*time.Second)
+	entries := readJobLog(t, jobLog)
+	require.Len(t, entries, 1)
+	assert.Equal(t, -1, entries[0].Exit)
+	assert.Contains(t, entries[0].Error, "timed out")
+}
+
+func TestResume(t *testing.T) {
+	dir, out := t.TempDir(), t.TempDir()
+	jobLog := filepath.Join(dir, "jobs")
+	res := xargs(t, "a\nb\nc\n", "-P", "1", "-spool", "-dir", out, "-joblog", jobLog, "sh", "-c", "test {} != b && echo {}")
+	assert.Error(t, res.err)
+
+	res = xargs(t, "a\nb\nc\n", "-resume", "-spool", "-dir", out, "-joblog", jobLog, "echo", "{}")
+	require.NoError(t, res.err)
+	assert.Equal(t, spooled(t, out, "4"), res.paths, "only b is run again, numbered after the first run")
+	assert.Equal(t, []string{"b\n"}, res.ordered())
+	for seq, want := range map[string]string{"1": "a\n", "2": "", "3": "c\n"} {
+		paths := spooled(t, out, seq)
+		require.Len(t, paths, 1)
+		got, err := os.ReadFile(paths[0])
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got), "the output of job %s of the first run is kept", seq)
+	}
+	entries := readJobLog(t, jobLog)
+	require.Len(t, entries, 4)
+	assert.Equal(t, jobEntry{Seq: 4, Input: []string{"b"}, Attempt: 1}, jobEntry{Seq: entries[3].Seq, Input: entries[3].Input, Attempt: entries[3].Attempt})
+}
+
+func TestStdin(t *testing.T) {
+	res := xargs(t, "a\nb\nc\n", "-stdin", "-n", "2", "cat")
+	require.NoError(t, res.err)
+	assert.Equal(t, []string{"a\nb\n", "c\n"}, res.sorted())
+
+	res = xargs(t, "a\x00b\x00", "-stdin", "-0", "-n", "2", "cat")
+	require.NoError(t, res.err)
+	assert.Equal(t, []string{"a\x00b\x00"}, res.sorted())
+}
+
+func TestWorkers(t *testing.T) {
+	res := xargs(t, "a\nb\nc\nd\ne\n", "-workers", "2", "cat")
+	require.NoError(t, res.err)
+	assert.Equal(t, []string{"a\nc\ne\n", "b\nd\n"}, res.sorted())
+
+	res = xargs(t, "a\n", "-workers", "2", "echo", "{}")
+	assert.Error(t, res.err, "the workers are shared, so the command can't have placeholders")
+}
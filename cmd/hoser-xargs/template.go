@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Arguments of the command are templates where placeholders in braces are replaced by (a part of) the input
+// record:
+//
+//	{}      the whole record (or the -I token)
+//	{N}     the Nth field of the record, starting at 1
+//	{name}  the field called name, from the header (-header) or the key of a JSON record (-json); without
+//	        -header or -json, {name} is not a placeholder
+//
+// Any placeholder can end with a modifier that treats the value as a path:
+//
+//	{.}  without extension    {/}  basename    {//}  dirname    {/.}  basename without extension
+//
+// Braces that do not contain a valid placeholder (e.g. a shell function body) are copied as is.
+
+// record is a single input record split into fields.
+type record struct {
+	line   string
+	fields []string          // Fields by position, empty for JSON records
+	named  map[string]string // Fields by name, from the header or JSON keys
+}
+
+// splitter splits the input lines into records according to the field flags.
+type splitter struct {
+	mode   string // "", "delim", "csv" or "json"
+	delim  string
+	header []string // Names of the fields by position, nil if there is no header
+}
+
+func (s *splitter) split(line string) (record, error) {
+	rec := record{line: line}
+	switch s.mode {
+	case "json":
+		var obj map[string]any
+		if err := json.Unmarshal([]byte(line), &obj); err != nil {
+			return rec, fmt.Errorf("invalid JSON record: %w", err)
+		}
+		rec.named = make(map[string]string)
+		flattenJSON("", obj, rec.named)
+		return rec, nil
+	case "csv":
+		rd := csv.NewReader(strings.NewReader(line))
+		rd.FieldsPerRecord = -1
+		fields, err := rd.Read()
+		if err != nil {
+			return rec, fmt.Errorf("invalid CSV record: %w", err)
+		}
+		rec.fields = fields
+	case "delim":
+		rec.fields = strings.Split(line, s.delim)
+	default:
+		rec.fields = strings.Fields(line)
+	}
+	if s.header != nil {
+		rec.named = make(map[string]string)
+		for i, name := range s.header {
+			if i < len(rec.fields) {
+				rec.named[name] = rec.fields[i]
+			}
+		}
+	}
+	return rec, nil
+}
+
+// flattenJSON stores every value of obj under its dotted path, e.g. {"a": {"b": 1}} as a.b=1. Strings are stored
+// as is, everything else as JSON.
+func flattenJSON(prefix string, value any, out map[string]string) {
+	switch v := value.(type) {
+	case map[string]any:
+		if prefix != "" {
+			raw, _ := json.Marshal(v)
+			out[prefix] = string(raw)
+			prefix += "."
+		}
+		for key, child := range v {
+			flattenJSON(prefix+key, child, out)
+		}
+	case string:
+		out[prefix] = v
+	default:
+		raw, _ := json.Marshal(v)
+		out[prefix] = string(raw)
+	}
+}
+
+type placeholder struct {
+	field string // "" is the whole record
+	mod   string // "", ".", "/", "//" or "/."
+}
+
+type segment struct {
+	lit string
+	ph  *placeholder
+}
+
+// template is a single argument of the command.
+type template struct {
+	segs []segment
+}
+
+var modifiers = []string{"/.", "//", "/", "."}
+
+// parsePlaceholder parses what is between the braces of a placeholder. Fields given by name are only placeholders
+// if the records have named fields.
+func parsePlaceholder(s string, named bool) (*placeholder, bool) {
+	for _, c := range s {
+		if !(c == '_' || c == '-' || c == '.' || c == '/' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return nil, false
+		}
+	}
+	ph := &placeholder{field: s}
+	for _, mod := range modifiers {
+		if strings.HasSuffix(s, mod) {
+			ph.field, ph.mod = strings.TrimSuffix(s, mod), mod
+			break
+		}
+	}
+	if strings.Contains(ph.field, "/") {
+		return nil, false
+	}
+	if _, err := strconv.Atoi(ph.field); ph.field != "" && err != nil && !named {
+		return nil, false
+	}
+	return ph, true
+}
+
+// compileTemplate parses an argument. Occurrences of token are the whole record like {}. named is whether the
+// records have named fields, so that {name} is a placeholder.
+func compileTemplate(arg, token string, named bool) template {
+	var t template
+	lit := strings.Builder{}
+	flush := func() {
+		if lit.Len() > 0 {
+			t.segs = append(t.segs, segment{lit: lit.String()})
+			lit.Reset()
+		}
+	}
+	for len(arg) > 0 {
+		if token != "" && strings.HasPrefix(arg, token) {
+			flush()
+			t.segs = append(t.segs, segment{ph: &placeholder{}})
+			arg = arg[len(token):]
+			continue
+		}
+		if arg[0] == '{' {
+			if end := strings.IndexByte(arg, '}'); end > 0 {
+				if ph, ok := parsePlaceholder(arg[1:end], named); ok {
+					flush()
+					t.segs = append(t.segs, segment{ph: ph})
+					arg = arg[end+1:]
+					continue
+				}
+			}
+		}
+		lit.WriteByte(arg[0])
+		arg = arg[1:]
+	}
+	flush()
+	return t
+}
+
+func (t template) hasPlaceholder() bool {
+	for _, seg := range t.segs {
+		if seg.ph != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// isPlaceholder reports if the argument is a single placeholder and nothing else.
+func (t template) isPlaceholder() bool {
+	return len(t.segs) == 1 && t.segs[0].ph != nil
+}
+
+func (t template) expand(recs []record) (string, error) {
+	var b strings.Builder
+	for _, seg := range t.segs {
+		if seg.ph == nil {
+			b.WriteString(seg.lit)
+			continue
+		}
+		for i, rec := range recs {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			value, err := seg.ph.value(rec)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+		}
+	}
+	return b.String(), nil
+}
+
+func (ph *placeholder) value(rec record) (string, error) {
+	value := rec.line
+	if ph.field != "" {
+		if i, err := strconv.Atoi(ph.field); err == nil {
+			if i < 1 || i > len(rec.fields) {
+				return "", fmt.Errorf("record has no field %d: %q", i, rec.line)
+			}
+			value = rec.fields[i-1]
+		} else {
+			v, ok := rec.named[ph.field]
+			if !ok {
+				return "", fmt.Errorf("record has no field '%s': %q", ph.field, rec.line)
+			}
+			value = v
+		}
+	}
+
+	switch ph.mod {
+	case ".":
+		value = strings.TrimSuffix(value, path.Ext(value))
+	case "/":
+		value = path.Base(value)
+	case "//":
+		value = path.Dir(value)
+	case "/.":
+		value = path.Base(value)
+		value = strings.TrimSuffix(value, path.Ext(value))
+	}
+	return value, nil
+}
+
+// buildArgs creates the command line for a batch of records. Arguments that are a single placeholder are replaced by
+// one argument per record, placeholders anywhere else are replaced by the values of all the records separated by
+// spaces. If no argument has a placeholder, the records are appended as arguments like xargs (unless they are fed
+// to stdin with -stdin).
+func buildArgs(templates []template, recs []record) ([]string, error) {
+	var args []string
+	replaced := false
+	for _, t := range templates {
+		if !t.hasPlaceholder() {
+			arg, _ := t.expand(nil)
+			args = append(args, arg)
+			continue
+		}
+		replaced = true
+		if t.isPlaceholder() {
+			for _, rec := range recs {
+				arg, err := t.expand([]record{rec})
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, arg)
+			}
+			continue
+		}
+		arg, err := t.expand(recs)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	if !replaced && !*stdinMode {
+		for _, rec := range recs {
+			args = append(args, rec.line)
+		}
+	}
+	return args, nil
+}
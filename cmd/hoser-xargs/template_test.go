@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func expand(t *testing.T, split *splitter, args []string, lines ...string) []string {
+	templates := make([]template, len(args))
+	for i, arg := range args {
+		templates[i] = compileTemplate(arg, "{}", split.header != nil || split.mode == "json")
+	}
+	got, err := batchArgs(split, templates, lines)
+	require.NoError(t, err)
+	return got
+}
+
+func TestTemplate(t *testing.T) {
+	split := &splitter{}
+	tests := []struct {
+		name  string
+		args  []string
+		lines []string
+		want  []string
+	}{
+		{"whole", []string{"echo", "{}"}, []string{"a b"}, []string{"echo", "a b"}},
+		{"append", []string{"echo"}, []string{"a", "b"}, []string{"echo", "a", "b"}},
+		{"batch", []string{"echo", "{}", "x{}"}, []string{"a", "b"}, []string{"echo", "a", "b", "xa b"}},
+		{"fields", []string{"cp", "{2}", "{1}"}, []string{"a b"}, []string{"cp", "b", "a"}},
+		{"path", []string{"{.}", "{/}", "{//}", "{/.}", "{1/.}"}, []string{"dir/game.pgn.bz2"},
+			[]string{"dir/game.pgn", "game.pgn.bz2", "dir", "game.pgn", "game.pgn"}},
+		{"literal braces", []string{"sh", "-c", "f() { echo {}; }; f"}, []string{"a"}, []string{"sh", "-c", "f() { echo a; }; f"}},
+		{"names without fields", []string{"jq", "-n", "{name}", "{name/}"}, []string{"a"}, []string{"jq", "-n", "{name}", "{name/}", "a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, expand(t, split, tt.args, tt.lines...))
+		})
+	}
+}
+
+func TestTemplateNamedFields(t *testing.T) {
+	csv := &splitter{mode: "csv", header: []string{"name", "size"}}
+	assert.Equal(t, []string{"a,b", "10"}, expand(t, csv, []string{"{name}", "{2}"}, `"a,b",10`))
+
+	json := &splitter{mode: "json"}
+	assert.Equal(t, []string{"x.txt", "3", "x"}, expand(t, json, []string{"{file}", "{meta.n}", "{file.}"},
+		`{"file": "x.txt", "meta": {"n": 3}}`))
+
+	_, err := batchArgs(&splitter{}, []template{compileTemplate("{3}", "{}", false)}, []string{"a b"})
+	assert.ErrorContains(t, err, "no field 3")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+
+	"github.com/masp/hoser-runtime/node"
+)
+
+// worker is a long-lived process started with -workers. Its outputs are exposed like the outputs of a job.
+type worker struct {
+	job
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	in    node.RecordWriter
+	dead  bool
+}
+
+func (w *worker) start(ctx context.Context, framing node.Framing) error {
+	if err := w.open(ctx); err != nil {
+		return err
+	}
+	w.cmd = exec.CommandContext(ctx, w.args[0], w.args[1:]...)
+	w.setOutputs(w.cmd)
+	var err error
+	w.stdin, err = w.cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	w.in = framing.NewWriter(w.stdin)
+	return w.cmd.Start()
+}
+
+// runWorkers starts -workers processes running the command and feeds them batches of lines round-robin over
+// their stdin. If a worker exits early, its batches go to the remaining workers.
+func runWorkers(ctx context.Context, input node.RecordReader, framing node.Framing, args []string, dir string, paths *pathWriter) error {
+	var pool []*worker
+	defer func() {
+		for _, w := range pool {
+			w.close()
+		}
+	}()
+	for i := 0; i < *workers; i++ {
+		w := &worker{job: job{seq: i + 1, dir: dir, args: args, paths: paths}}
+		pool = append(pool, w)
+		if err := w.start(ctx, framing); err != nil {
+			// The workers already started would wait for the rest of their stdin forever
+			for _, started := range pool[:i] {
+				started.stdin.Close()
+				started.cmd.Wait()
+			}
+			return fmt.Errorf("start worker %d: %w", w.seq, err)
+		}
+	}
+
+	feed := &feeder{pool: pool, alive: len(pool)}
+	for feed.alive > 0 && ctx.Err() == nil {
+		batch, err := readBatch(input, *batchSize)
+		if err != nil {
+			n.Errorf("read stdin: %v", err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		feed.send(batch)
+	}
+
+	failed := 0
+	for _, w := range pool {
+		w.stdin.Close()
+		if err := w.cmd.Wait(); err != nil {
+			n.Errorf("[worker %d] exited: %v", w.seq, err)
+			failed++
+		}
+	}
+	if feed.alive == 0 {
+		return fmt.Errorf("all workers exited before the end of input")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d workers failed", failed)
+	}
+	return nil
+}
+
+// feeder hands out batches to the workers round-robin.
+type feeder struct {
+	pool  []*worker
+	next  int
+	alive int
+}
+
+// send writes the batch to the next worker that is alive. If a worker stops accepting input partway, the lines it
+// did not take go to the next worker, so no line is sent twice.
+func (f *feeder) send(batch []string) {
+	for len(batch) > 0 && f.alive > 0 {
+		w := f.pool[f.next]
+		f.next = (f.next + 1) % len(f.pool)
+		if w.dead {
+			continue
+		}
+		for len(batch) > 0 {
+			if err := w.in.WriteRecord([]byte(batch[0])); err != nil {
+				n.Errorf("[worker %d] stopped accepting input: %v", w.seq, err)
+				w.dead = true
+				f.alive--
+				break
+			}
+			batch = batch[1:]
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// limitedInput is the stdin of a worker that exits after taking limit lines.
+type limitedInput struct {
+	lines []string
+	limit int
+}
+
+func (in *limitedInput) WriteRecord(record []byte) error {
+	if len(in.lines) == in.limit {
+		return io.ErrClosedPipe
+	}
+	in.lines = append(in.lines, string(record))
+	return nil
+}
+
+func TestFeeder(t *testing.T) {
+	a, b := &limitedInput{limit: 3}, &limitedInput{limit: -1}
+	feed := &feeder{pool: []*worker{{in: a}, {in: b}}, alive: 2}
+	feed.send([]string{"1", "2"})
+	feed.send([]string{"3", "4"})
+	feed.send([]string{"5", "6"}) // a takes 5 and exits, b gets only 6
+	feed.send([]string{"7"})
+	assert.Equal(t, []string{"1", "2", "5"}, a.lines)
+	assert.Equal(t, []string{"3", "4", "6", "7"}, b.lines)
+	assert.Equal(t, 1, feed.alive)
+
+	b.limit = len(b.lines)
+	feed.send([]string{"8"})
+	assert.Equal(t, 0, feed.alive)
+}
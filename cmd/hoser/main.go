@@ -1,19 +1,26 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
+	"github.com/masp/hoser-runtime/backend"
 	"github.com/masp/hoser-runtime/osruntime"
 	"github.com/masp/hoser-runtime/plan"
 )
 
 var (
-	debug = flag.Bool("d", false, "Print debug information to stderr")
+	debug   = flag.Bool("d", false, "Print debug information to stderr")
+	dryRun  = flag.Bool("n", false, "Build the pipe and print what would be executed without starting anything")
+	jsonOut = flag.Bool("json", false, "With -n, print the explanation as JSON")
+	runtime = flag.String("runtime", "os", "The runtime used to execute the pipe")
 )
 
 func main() {
@@ -24,7 +31,12 @@ func main() {
 		log.SetOutput(os.Stderr)
 	}
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "usage: %s [pipes]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "usage: %s [pipes]\n       %s describe exe\n", os.Args[0], os.Args[0])
+	}
+
+	if flag.Arg(0) == "describe" {
+		describe(flag.Arg(1))
+		return
 	}
 
 	path, pipeName, err := parseFile()
@@ -58,7 +70,17 @@ func main() {
 			return
 		}
 	}
-	prog, err := osruntime.Build(*chosenPipe, map[string]any{
+	rt, err := backend.Lookup(*runtime)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+	if osRt, ok := rt.(osruntime.Runtime); ok && *dryRun {
+		// Nothing is started, so don't spend time asking every executable for its manifest
+		osRt.NoCheck = true
+		rt = osRt
+	}
+	prog, err := rt.Build(*chosenPipe, map[string]any{
 		"stdin":  os.Stdin,
 		"stdout": os.Stdout,
 		"stderr": os.Stderr,
@@ -67,11 +89,60 @@ func main() {
 		fmt.Fprintf(os.Stderr, "build failed: %v", err)
 		return
 	}
+	if *dryRun {
+		osProg, ok := prog.(*osruntime.Program)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "runtime '%s' does not support -n\n", *runtime)
+			os.Exit(2)
+		}
+		ex := osProg.Explain()
+		if *jsonOut {
+			err = ex.WriteJSON(os.Stdout)
+		} else {
+			err = ex.WriteText(os.Stdout)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		osProg.Close()
+		return
+	}
 	err = prog.Start()
 	if err != nil {
 		log.Fatal(err)
 	}
-	prog.Wait()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("received %v, stopping", sig)
+		prog.Stop()
+	}()
+
+	err = prog.Wait()
+	if err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+}
+
+// describe prints the manifest of an executable or builtin.
+func describe(exe string) {
+	if exe == "" {
+		fmt.Fprintf(os.Stderr, "describe: no executable specified\n")
+		os.Exit(2)
+	}
+	m, err := osruntime.Describe(exe)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func parseFile() (string, string, error) {
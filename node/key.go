@@ -0,0 +1,94 @@
+package node
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// Key extracts the key of a record, e.g. to sort, join or partition records.
+type Key struct {
+	Field   int    // The field (starting at 1) that is the key, 0 is the whole record
+	Sep     string // The separator between fields, "" splits on runs of spaces and tabs
+	Numeric bool   // Compare keys as numbers instead of bytes
+}
+
+func (k Key) String() string {
+	if k.Field == 0 {
+		return "record"
+	}
+	kind := ""
+	if k.Numeric {
+		kind = " (numeric)"
+	}
+	return fmt.Sprintf("field %d%s", k.Field, kind)
+}
+
+// Extract returns the key of a record. Records without the field have an empty key.
+func (k Key) Extract(record []byte) []byte {
+	if k.Field <= 0 {
+		return record
+	}
+	var fields [][]byte
+	if k.Sep == "" {
+		fields = bytes.Fields(record)
+	} else {
+		fields = bytes.SplitN(record, []byte(k.Sep), k.Field+1)
+	}
+	if k.Field > len(fields) {
+		return nil
+	}
+	return fields[k.Field-1]
+}
+
+// Compare compares the keys of two records like bytes.Compare.
+func (k Key) Compare(a, b []byte) int {
+	return k.CompareKeys(k.Extract(a), k.Extract(b))
+}
+
+// CompareKeys compares two already extracted keys. Numeric keys that are not numbers sort before all numbers.
+func (k Key) CompareKeys(a, b []byte) int {
+	if !k.Numeric {
+		return bytes.Compare(a, b)
+	}
+	x, errX := strconv.ParseFloat(string(bytes.TrimSpace(a)), 64)
+	y, errY := strconv.ParseFloat(string(bytes.TrimSpace(b)), 64)
+	switch {
+	case errX != nil && errY != nil:
+		return bytes.Compare(a, b)
+	case errX != nil:
+		return -1
+	case errY != nil:
+		return 1
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// MapKey returns a string for an extracted key that is the same for all keys that compare equal, to look keys up in
+// a map. Numeric keys are normalized, so that e.g. 1 and 1.0 are the same key.
+func (k Key) MapKey(key []byte) string {
+	if k.Numeric {
+		if f, err := strconv.ParseFloat(string(bytes.TrimSpace(key)), 64); err == nil {
+			return "n" + strconv.FormatFloat(f, 'g', -1, 64)
+		}
+	}
+	return "s" + string(key)
+}
+
+// KeyFlags registers the flags that select the key of a record. The returned key is set once the flags are
+// parsed.
+//
+//	-key n     the key is the nth field (default 0, the whole record)
+//	-fs s      fields are separated by s (default runs of whitespace)
+//	-numeric   compare keys as numbers
+func (n *Node) KeyFlags() *Key {
+	k := new(Key)
+	n.Flags.IntVar(&k.Field, "key", 0, "the key is this field, starting at 1 (0 is the whole record)")
+	n.Flags.StringVar(&k.Sep, "fs", "", "the separator between fields (default runs of whitespace)")
+	n.Flags.BoolVar(&k.Numeric, "numeric", false, "compare keys as numbers")
+	return k
+}
@@ -0,0 +1,313 @@
+// Package node is an SDK for writing programs that run as processes in a hoser pipe. A program declares its ports
+// and flags on a Node, which takes care of parsing the command line, opening stream ports from stdio or the
+// arguments the runtime passes (e.g. /dev/fd/3), reading and writing framed records and reporting errors and
+// progress back to the runtime.
+//
+//	n := node.New("hoser-example")
+//	in := n.Input("stdin", plan.TypeStream, "records to process")
+//	out := n.Output("stdout", plan.TypeStream, "processed records")
+//	n.Parse(os.Args[1:])
+//
+// Every node understands --hoser-describe, which prints the manifest of the node (its ports, how they are passed as
+// arguments and its flags) as JSON so that plans can be generated and checked for it.
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/masp/hoser-runtime/plan"
+)
+
+// Node is a hoser-aware program.
+type Node struct {
+	Name  string
+	Flags *flag.FlagSet
+
+	ports    []*Port // in declaration order
+	rest     []string
+	describe *bool
+	report   *reporter
+}
+
+// Port is a port of the node. Ports named stdin, stdout and stderr are connected to the stdio of the process,
+// every other port is passed as a positional argument in the order the ports were declared.
+type Port struct {
+	plan.Port
+	Dir      plan.PortDir
+	Usage    string
+	Variadic bool // The port consumes all remaining positional arguments
+
+	values []string
+}
+
+// New creates a node with its own flag set. The flag set exits on errors like the default flag.CommandLine.
+func New(name string) *Node {
+	n := &Node{
+		Name:   name,
+		Flags:  flag.NewFlagSet(name, flag.ExitOnError),
+		report: newReporter(name),
+	}
+	n.describe = n.Flags.Bool("hoser-describe", false, "print the manifest of this node as JSON and exit")
+	n.Flags.Usage = n.usage
+	return n
+}
+
+func (n *Node) addPort(name string, typ plan.VarType, dir plan.PortDir, usage string) *Port {
+	p := &Port{Port: plan.Port{Name: name, Type: typ}, Dir: dir, Usage: usage}
+	n.ports = append(n.ports, p)
+	return p
+}
+
+// Input declares an input port.
+func (n *Node) Input(name string, typ plan.VarType, usage string) *Port {
+	return n.addPort(name, typ, plan.PortIn, usage)
+}
+
+// Output declares an output port.
+func (n *Node) Output(name string, typ plan.VarType, usage string) *Port {
+	return n.addPort(name, typ, plan.PortOut, usage)
+}
+
+// Inputs declares a stream input port that takes all the remaining positional arguments, e.g. the files of a
+// merge. It must be the last positional port.
+func (n *Node) Inputs(name string, usage string) *Port {
+	p := n.addPort(name, plan.TypeStream, plan.PortIn, usage)
+	p.Variadic = true
+	return p
+}
+
+// Outputs declares a stream output port that takes all the remaining positional arguments.
+func (n *Node) Outputs(name string, usage string) *Port {
+	p := n.addPort(name, plan.TypeStream, plan.PortOut, usage)
+	p.Variadic = true
+	return p
+}
+
+// IsStdio reports whether the port is connected to stdin, stdout or stderr instead of passed as an argument.
+func (p *Port) IsStdio() bool {
+	return p.Name == "stdin" || p.Name == "stdout" || p.Name == "stderr"
+}
+
+// Parse parses the flags and binds the positional arguments to the ports. If -hoser-describe is given, the
+// manifest is printed to stdout and the program exits.
+func (n *Node) Parse(args []string) error {
+	if err := n.Flags.Parse(args); err != nil {
+		return err
+	}
+	if *n.describe {
+		if err := n.WriteManifest(os.Stdout); err != nil {
+			n.Fatalf("describe: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	positional := n.Flags.Args()
+	for _, p := range n.ports {
+		if p.IsStdio() {
+			continue
+		}
+		if p.Variadic {
+			p.values, positional = positional, nil
+			break
+		}
+		if len(positional) == 0 {
+			return fmt.Errorf("missing argument for port '%s'", p.Name)
+		}
+		p.values, positional = positional[:1], positional[1:]
+	}
+	n.rest = positional
+	return nil
+}
+
+// Args returns the positional arguments that were not bound to any port.
+func (n *Node) Args() []string {
+	return n.rest
+}
+
+// StopTimeout is how long run has to return after SIGINT or SIGTERM before the node exits anyway. Nodes only look
+// at the context between records, so one that is blocked reading a record would never see it.
+var StopTimeout = 2 * time.Second
+
+// Run parses the command line in os.Args and calls run with a context that is cancelled on SIGINT or SIGTERM. If
+// run returns an error, it is reported and the program exits with code 1. If run does not return within
+// StopTimeout of the signal, or a second signal arrives, the program exits right away.
+func (n *Node) Run(run func(ctx context.Context) error) {
+	if err := n.Parse(os.Args[1:]); err != nil {
+		fmt.Fprintf(n.Flags.Output(), "%s: %v\n", n.Name, err)
+		n.Flags.Usage()
+		os.Exit(2)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	returned := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-returned:
+			return
+		}
+		stop() // a second signal gets the default action, which kills the program
+		select {
+		case <-time.After(StopTimeout):
+			n.Fatalf("not stopped %s after the signal, exiting", StopTimeout)
+		case <-returned:
+		}
+	}()
+	err := run(ctx)
+	close(returned)
+	if err != nil {
+		n.Fatalf("%v", err)
+	}
+}
+
+// Value returns the value of a string port.
+func (p *Port) Value() string {
+	if len(p.values) == 0 {
+		return ""
+	}
+	return p.values[0]
+}
+
+// Paths returns the arguments bound to the port. Stdio ports have no paths.
+func (p *Port) Paths() []string {
+	return p.values
+}
+
+// Open opens the stream of an input port. Stdin is returned as is, otherwise the argument is opened as a file.
+func (p *Port) Open() (io.ReadCloser, error) {
+	if p.Name == "stdin" {
+		return os.Stdin, nil
+	}
+	if len(p.values) == 0 {
+		return nil, fmt.Errorf("port '%s' is not bound", p.Name)
+	}
+	return OpenInput(p.values[0])
+}
+
+// OpenAll opens every stream of a variadic input port.
+func (p *Port) OpenAll() ([]io.ReadCloser, error) {
+	var streams []io.ReadCloser
+	for _, path := range p.values {
+		rd, err := OpenInput(path)
+		if err != nil {
+			for _, s := range streams {
+				s.Close()
+			}
+			return nil, err
+		}
+		streams = append(streams, rd)
+	}
+	return streams, nil
+}
+
+// Create opens the stream of an output port. Stdout and stderr are returned as is, otherwise the argument is opened
+// for writing, creating it if it does not exist.
+func (p *Port) Create() (io.WriteCloser, error) {
+	switch p.Name {
+	case "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	}
+	if len(p.values) == 0 {
+		return nil, fmt.Errorf("port '%s' is not bound", p.Name)
+	}
+	return CreateOutput(p.values[0])
+}
+
+// CreateAll opens every stream of a variadic output port.
+func (p *Port) CreateAll() ([]io.WriteCloser, error) {
+	var streams []io.WriteCloser
+	for _, path := range p.values {
+		wr, err := CreateOutput(path)
+		if err != nil {
+			for _, s := range streams {
+				s.Close()
+			}
+			return nil, err
+		}
+		streams = append(streams, wr)
+	}
+	return streams, nil
+}
+
+// OpenInput opens a stream given as an argument, "-" is stdin.
+func OpenInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return os.Stdin, nil
+	}
+	return os.Open(path)
+}
+
+// CreateOutput opens a stream given as an argument for writing, "-" is stdout. Existing files are truncated
+// unless they are not regular files (pipes, /dev/fd/N), which are written as is.
+func CreateOutput(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return os.Stdout, nil
+	}
+	if st, err := os.Stat(path); err == nil && !st.Mode().IsRegular() {
+		return os.OpenFile(path, os.O_WRONLY, 0)
+	}
+	return os.Create(path)
+}
+
+// Manifest describes the ports and flags of the node, so that plans using it can be generated and checked.
+func (n *Node) Manifest() plan.Manifest {
+	m := plan.Manifest{Name: n.Name, In: []plan.Port{}, Out: []plan.Port{}, Args: []plan.ArgSpec{}, Flags: []plan.FlagSpec{}}
+	for _, p := range n.ports {
+		if p.Dir == plan.PortIn {
+			m.In = append(m.In, p.Port)
+		} else {
+			m.Out = append(m.Out, p.Port)
+		}
+		if !p.IsStdio() {
+			m.Args = append(m.Args, plan.ArgSpec{Name: p.Name, Variadic: p.Variadic})
+		}
+	}
+	n.Flags.VisitAll(func(f *flag.Flag) {
+		if f.Name == "hoser-describe" {
+			return
+		}
+		typ, usage := flag.UnquoteUsage(f)
+		if typ == "" {
+			typ = "bool"
+		}
+		m.Flags = append(m.Flags, plan.FlagSpec{Name: f.Name, Type: typ, Default: f.DefValue, Usage: usage})
+	})
+	return m
+}
+
+// WriteManifest writes the manifest as JSON.
+func (n *Node) WriteManifest(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(n.Manifest())
+}
+
+func (n *Node) usage() {
+	w := n.Flags.Output()
+	fmt.Fprintf(w, "usage: %s [flags]", n.Name)
+	for _, p := range n.ports {
+		if p.IsStdio() {
+			continue
+		}
+		if p.Variadic {
+			fmt.Fprintf(w, " %s...", p.Name)
+		} else {
+			fmt.Fprintf(w, " %s", p.Name)
+		}
+	}
+	fmt.Fprintln(w)
+	for _, p := range n.ports {
+		fmt.Fprintf(w, "  %s (%s %s): %s\n", p.Name, p.Dir, p.Type, p.Usage)
+	}
+	n.Flags.PrintDefaults()
+}
@@ -0,0 +1,196 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/masp/hoser-runtime/plan"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePorts(t *testing.T) {
+	n := New("test")
+	verbose := n.Flags.Bool("v", false, "verbose")
+	n.Input("stdin", plan.TypeStream, "")
+	filter := n.Input("filter", plan.TypeString, "")
+	inputs := n.Inputs("inputs", "")
+	n.Output("stdout", plan.TypeStream, "")
+
+	require.NoError(t, n.Parse([]string{"-v", "cats", "a", "b"}))
+	assert.True(t, *verbose)
+	assert.Equal(t, "cats", filter.Value())
+	assert.Equal(t, []string{"a", "b"}, inputs.Paths())
+	assert.Empty(t, n.Args())
+
+	m := n.Manifest()
+	assert.Equal(t, []plan.Port{{Name: "stdin", Type: plan.TypeStream}, {Name: "filter", Type: plan.TypeString},
+		{Name: "inputs", Type: plan.TypeStream}}, m.In)
+	assert.Equal(t, []plan.ArgSpec{{Name: "filter"}, {Name: "inputs", Variadic: true}}, m.Args)
+	assert.Equal(t, []plan.FlagSpec{{Name: "v", Type: "bool", Default: "false", Usage: "verbose"}}, m.Flags)
+}
+
+func TestParseMissingPort(t *testing.T) {
+	n := New("test")
+	n.Input("filter", plan.TypeString, "")
+	assert.Error(t, n.Parse(nil))
+}
+
+func TestParseRest(t *testing.T) {
+	n := New("test")
+	n.Input("stdin", plan.TypeStream, "")
+	require.NoError(t, n.Parse([]string{"echo", "{}"}))
+	assert.Equal(t, []string{"echo", "{}"}, n.Args())
+}
+
+func readAll(t *testing.T, rd RecordReader) []string {
+	var records []string
+	for {
+		rec, err := rd.ReadRecord()
+		if err == io.EOF {
+			return records
+		}
+		require.NoError(t, err)
+		records = append(records, string(rec))
+	}
+}
+
+func TestDelim(t *testing.T) {
+	assert.Equal(t, []string{"a", "", "bc"}, readAll(t, Delim("\n").NewReader(strings.NewReader("a\n\nbc"))))
+	assert.Equal(t, []string{"a", "b"}, readAll(t, Delim("\x00").NewReader(strings.NewReader("a\x00b\x00"))))
+	assert.Equal(t, []string{"a\nb", "c"}, readAll(t, Delim("\n\n").NewReader(strings.NewReader("a\nb\n\nc\n\n"))))
+
+	// A last record cut short by a read error is not returned
+	errRead := errors.New("read failed")
+	rd := Delim("\n").NewReader(io.MultiReader(strings.NewReader("a\nb"), iotest.ErrReader(errRead)))
+	rec, err := rd.ReadRecord()
+	require.NoError(t, err)
+	assert.Equal(t, "a", string(rec))
+	_, err = rd.ReadRecord()
+	assert.ErrorIs(t, err, errRead)
+
+	var buf bytes.Buffer
+	wr := Delim("\r\n").NewWriter(&buf)
+	require.NoError(t, wr.WriteRecord([]byte("a")))
+	require.NoError(t, wr.WriteRecord([]byte("b")))
+	assert.Equal(t, "a\r\nb\r\n", buf.String())
+}
+
+func TestDelimChunks(t *testing.T) {
+	// The delimiter straddles the end of the first chunk
+	big := strings.Repeat("x", ChunkSize) + "y"
+	rd := Delim("yz").NewReader(strings.NewReader(big + "z" + "end"))
+	assert.Equal(t, []string{big[:len(big)-1], "end"}, readAll(t, rd))
+
+	rd = Delim("\n").NewReader(strings.NewReader(big + "\nend"))
+	cr := rd.(ChunkReader)
+	var buf bytes.Buffer
+	cw := Delim("\n").NewWriter(&buf).(ChunkWriter)
+	for {
+		chunk, more, err := cr.ReadChunk()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.NoError(t, cw.WriteChunk(chunk, more))
+	}
+	assert.Equal(t, big+"\nend\n", buf.String())
+}
+
+func TestLengthPrefixed(t *testing.T) {
+	var buf bytes.Buffer
+	wr := LengthPrefixed{}.NewWriter(&buf)
+	big := strings.Repeat("x", ChunkSize*2+1)
+	for _, rec := range []string{"a\nb", "", big} {
+		require.NoError(t, wr.WriteRecord([]byte(rec)))
+	}
+	assert.Equal(t, []byte{0, 0, 0, 3, 'a', '\n', 'b'}, buf.Bytes()[:7])
+	assert.Equal(t, []string{"a\nb", "", big}, readAll(t, LengthPrefixed{}.NewReader(&buf)))
+
+	_, err := LengthPrefixed{}.NewReader(bytes.NewReader([]byte{0, 0, 0, 5, 'a'})).ReadRecord()
+	assert.Error(t, err)
+}
+
+func TestJSONLines(t *testing.T) {
+	rd := JSONLines{}.NewReader(strings.NewReader("{\"a\": 1}\n{\n  \"b\": [1, 2]\n}\n\"s\"\n"))
+	assert.Equal(t, []string{`{"a": 1}`, "{\n  \"b\": [1, 2]\n}", `"s"`}, readAll(t, rd))
+
+	var buf bytes.Buffer
+	wr := JSONLines{}.NewWriter(&buf)
+	require.NoError(t, wr.WriteRecord([]byte("{\n  \"b\": [1, 2]\n}")))
+	assert.Error(t, wr.WriteRecord([]byte("{")))
+	assert.Equal(t, "{\"b\":[1,2]}\n", buf.String())
+}
+
+func TestFramingFlags(t *testing.T) {
+	n := New("test")
+	f := n.FramingFlags()
+	require.NoError(t, n.Parse([]string{"-sep", `\r\n`}))
+	assert.Equal(t, Delim("\r\n"), *f)
+
+	n = New("test")
+	f = n.FramingFlags()
+	require.NoError(t, n.Parse([]string{"-framing", "jsonl"}))
+	assert.Equal(t, JSONLines{}, *f)
+}
+
+func TestKey(t *testing.T) {
+	k := Key{Field: 2}
+	assert.Equal(t, "b", string(k.Extract([]byte("a  b c"))))
+	assert.Nil(t, k.Extract([]byte("a")))
+	assert.Equal(t, "b c", string(Key{Field: 1, Sep: ","}.Extract([]byte("b c,a"))))
+	assert.Equal(t, "b", string(Key{Field: 2, Sep: ","}.Extract([]byte("a,b,c"))))
+
+	assert.Equal(t, -1, Key{}.Compare([]byte("10"), []byte("9")))
+	num := Key{Numeric: true}
+	assert.Equal(t, -1, num.Compare([]byte("9"), []byte("10")))
+	assert.Equal(t, 0, num.Compare([]byte("1.0"), []byte("1")))
+	assert.Equal(t, -1, num.Compare([]byte("x"), []byte("1")))
+	assert.Equal(t, num.MapKey([]byte("1")), num.MapKey([]byte("1.0")))
+	assert.NotEqual(t, num.MapKey([]byte("1")), num.MapKey([]byte("x")))
+	assert.NotEqual(t, Key{}.MapKey([]byte("1")), Key{}.MapKey([]byte("1.0")))
+}
+
+// TestRunSignal runs the test binary as a node that is blocked reading stdin and checks that SIGTERM ends it.
+func TestRunSignal(t *testing.T) {
+	if os.Getenv("NODE_TEST_RUN") == "1" {
+		n := New("test")
+		os.Args = []string{"test"}
+		StopTimeout = 100 * time.Millisecond
+		n.Run(func(ctx context.Context) error {
+			io.ReadAll(os.Stdin) // never sees ctx
+			return nil
+		})
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRunSignal$")
+	cmd.Env = append(os.Environ(), "NODE_TEST_RUN=1")
+	stdin, err := cmd.StdinPipe()
+	require.NoError(t, err)
+	defer stdin.Close()
+	require.NoError(t, cmd.Start())
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, cmd.Process.Signal(syscall.SIGTERM))
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err := <-exited:
+		var exitErr *exec.ExitError
+		require.ErrorAs(t, err, &exitErr)
+		assert.Equal(t, 1, exitErr.ExitCode())
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("node did not exit after SIGTERM")
+	}
+}
@@ -0,0 +1,332 @@
+package node
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// ChunkSize is the size of the buffer readers use. Records larger than a chunk are still read completely by
+// ReadRecord, but can be streamed a chunk at a time by readers that implement ChunkReader.
+const ChunkSize = 64 * 1024
+
+// RecordReader reads the records of a stream one at a time.
+type RecordReader interface {
+	// ReadRecord returns the next record without its framing. It returns io.EOF when there are no more records. The
+	// returned slice is only valid until the next call.
+	ReadRecord() ([]byte, error)
+}
+
+// ChunkReader is implemented by readers that can return a record in pieces, so that records of any size can be
+// copied with bounded memory.
+type ChunkReader interface {
+	// ReadChunk returns the next piece of the current record. more is true if the record continues in the next
+	// chunk. It returns io.EOF when there are no more records. The returned slice is only valid until the next call.
+	ReadChunk() (chunk []byte, more bool, err error)
+}
+
+// RecordWriter writes records to a stream.
+type RecordWriter interface {
+	// WriteRecord writes a record with its framing in a single write, so that concurrent writers to the same
+	// stream never interleave records.
+	WriteRecord(record []byte) error
+}
+
+// ChunkWriter is implemented by writers that can write a record in pieces as read by a ChunkReader. The caller
+// must make sure no other record is written to the stream until the last chunk (more is false) is written.
+type ChunkWriter interface {
+	WriteChunk(chunk []byte, more bool) error
+}
+
+// Framing describes how records are separated in a stream.
+type Framing interface {
+	NewReader(r io.Reader) RecordReader
+	NewWriter(w io.Writer) RecordWriter
+	String() string
+}
+
+// Delim is a framing where every record is terminated by a delimiter, e.g. "\n" for lines or "\n\n" for
+// paragraphs. The last record of a stream does not need to be terminated.
+type Delim string
+
+func (d Delim) String() string {
+	return fmt.Sprintf("delim(%q)", string(d))
+}
+
+func (d Delim) NewReader(r io.Reader) RecordReader {
+	return &delimReader{r: r, delim: []byte(d), buf: make([]byte, ChunkSize+len(d))}
+}
+
+func (d Delim) NewWriter(w io.Writer) RecordWriter {
+	return &delimWriter{w: w, delim: []byte(d)}
+}
+
+type delimReader struct {
+	r          io.Reader
+	delim      []byte
+	buf        []byte
+	start, end int   // The unread data in buf
+	err        error // The error of the last read from r
+	inRecord   bool  // The last chunk returned had more set
+	record     []byte
+}
+
+func (r *delimReader) ReadChunk() ([]byte, bool, error) {
+	for {
+		if i := bytes.Index(r.buf[r.start:r.end], r.delim); i >= 0 {
+			chunk := r.buf[r.start : r.start+i]
+			r.start += i + len(r.delim)
+			r.inRecord = false
+			return chunk, false, nil
+		}
+		if r.err != nil {
+			// The last record may lack a trailing delimiter, but a record cut short by a failed read is dropped
+			if r.err == io.EOF && (r.start < r.end || r.inRecord) {
+				chunk := r.buf[r.start:r.end]
+				r.start, r.inRecord = r.end, false
+				return chunk, false, nil
+			}
+			return nil, false, r.err
+		}
+
+		if r.start > 0 {
+			copy(r.buf, r.buf[r.start:r.end])
+			r.end -= r.start
+			r.start = 0
+		}
+		if r.end == len(r.buf) {
+			// The buffer is full without a delimiter, return all of it except what may be the start of one
+			keep := len(r.delim) - 1
+			chunk := r.buf[:r.end-keep]
+			r.start = r.end - keep
+			r.inRecord = true
+			return chunk, true, nil
+		}
+		var n int
+		n, r.err = r.r.Read(r.buf[r.end:])
+		r.end += n
+	}
+}
+
+func (r *delimReader) ReadRecord() ([]byte, error) {
+	return readChunks(r)
+}
+
+// readChunks reads a whole record from a ChunkReader.
+func readChunks(r interface {
+	ChunkReader
+	recordBuf() *[]byte
+}) ([]byte, error) {
+	buf := r.recordBuf()
+	*buf = (*buf)[:0]
+	for {
+		chunk, more, err := r.ReadChunk()
+		if err != nil {
+			if err == io.EOF && len(*buf) > 0 {
+				return *buf, nil
+			}
+			return nil, err
+		}
+		if !more && len(*buf) == 0 {
+			return chunk, nil // the common case, no need to copy
+		}
+		*buf = append(*buf, chunk...)
+		if !more {
+			return *buf, nil
+		}
+	}
+}
+
+func (r *delimReader) recordBuf() *[]byte { return &r.record }
+
+type delimWriter struct {
+	w     io.Writer
+	delim []byte
+	buf   []byte
+}
+
+func (w *delimWriter) WriteRecord(record []byte) error {
+	w.buf = append(append(w.buf[:0], record...), w.delim...)
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+func (w *delimWriter) WriteChunk(chunk []byte, more bool) error {
+	if !more {
+		return w.WriteRecord(chunk)
+	}
+	_, err := w.w.Write(chunk)
+	return err
+}
+
+// LengthPrefixed is a framing for binary records where every record is preceded by its length as a 4 byte big
+// endian integer.
+type LengthPrefixed struct{}
+
+func (LengthPrefixed) String() string {
+	return "length-prefixed"
+}
+
+func (LengthPrefixed) NewReader(r io.Reader) RecordReader {
+	return &lengthReader{rd: bufio.NewReaderSize(r, ChunkSize)}
+}
+
+func (LengthPrefixed) NewWriter(w io.Writer) RecordWriter {
+	return &lengthWriter{w: w}
+}
+
+type lengthReader struct {
+	rd        *bufio.Reader
+	remaining int // The bytes left of the current record
+	inRecord  bool
+	buf       []byte
+	record    []byte
+}
+
+func (r *lengthReader) ReadChunk() ([]byte, bool, error) {
+	if !r.inRecord {
+		var header [4]byte
+		if _, err := io.ReadFull(r.rd, header[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, false, fmt.Errorf("truncated record length: %w", err)
+			}
+			return nil, false, err
+		}
+		r.remaining = int(binary.BigEndian.Uint32(header[:]))
+		r.inRecord = true
+	}
+	size := r.remaining
+	if size > ChunkSize {
+		size = ChunkSize
+	}
+	if cap(r.buf) < size {
+		r.buf = make([]byte, size)
+	}
+	chunk := r.buf[:size]
+	if _, err := io.ReadFull(r.rd, chunk); err != nil {
+		return nil, false, fmt.Errorf("truncated record: %w", err)
+	}
+	r.remaining -= size
+	r.inRecord = r.remaining > 0
+	return chunk, r.inRecord, nil
+}
+
+func (r *lengthReader) ReadRecord() ([]byte, error) {
+	return readChunks(r)
+}
+
+func (r *lengthReader) recordBuf() *[]byte { return &r.record }
+
+type lengthWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (w *lengthWriter) WriteRecord(record []byte) error {
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(record)))
+	w.buf = append(append(w.buf[:0], header[:]...), record...)
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+// JSONLines is a framing where every record is a JSON value. Values may span several lines when read, but are
+// always written compacted on a single line.
+type JSONLines struct{}
+
+func (JSONLines) String() string {
+	return "jsonl"
+}
+
+func (JSONLines) NewReader(r io.Reader) RecordReader {
+	return &jsonReader{dec: json.NewDecoder(r)}
+}
+
+func (JSONLines) NewWriter(w io.Writer) RecordWriter {
+	return &jsonWriter{w: w}
+}
+
+type jsonReader struct {
+	dec *json.Decoder
+	raw json.RawMessage
+}
+
+func (r *jsonReader) ReadRecord() ([]byte, error) {
+	r.raw = r.raw[:0]
+	if err := r.dec.Decode(&r.raw); err != nil {
+		return nil, err
+	}
+	return r.raw, nil
+}
+
+type jsonWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func (w *jsonWriter) WriteRecord(record []byte) error {
+	w.buf.Reset()
+	if err := json.Compact(&w.buf, record); err != nil {
+		return fmt.Errorf("invalid JSON record: %w", err)
+	}
+	w.buf.WriteByte('\n')
+	_, err := w.w.Write(w.buf.Bytes())
+	return err
+}
+
+// FramingFlags registers the flags that select the framing of the records the node reads and writes. The returned
+// pointer is set once the flags are parsed. Every hoser tool that handles records takes these flags, so a pipe of
+// tools agrees on the record boundaries when they are all given the same -framing and -sep.
+//
+//	-framing f   delim (default), length (4 byte big endian length prefix) or jsonl
+//	-sep s       with delim, records are terminated by s (default newline), escapes like \r\n are allowed
+func (n *Node) FramingFlags() *Framing {
+	return AddFramingFlags(n.Flags)
+}
+
+// AddFramingFlags registers the flags of FramingFlags on any flag set, e.g. for builtins that parse their own
+// arguments.
+func AddFramingFlags(fs *flag.FlagSet) *Framing {
+	f := new(Framing)
+	*f = Delim("\n")
+	kind := "delim"
+	sep := "\n"
+	set := func() error {
+		switch kind {
+		case "delim":
+			if sep == "" {
+				return fmt.Errorf("sep cannot be empty")
+			}
+			*f = Delim(sep)
+		case "length":
+			*f = LengthPrefixed{}
+		case "jsonl":
+			*f = JSONLines{}
+		default:
+			return fmt.Errorf("unknown framing '%s'", kind)
+		}
+		return nil
+	}
+	fs.Func("framing", "how records are framed: delim, length or jsonl (default delim)", func(s string) error {
+		kind = s
+		return set()
+	})
+	fs.Func("sep", "with -framing delim, records are terminated by this string (default newline)", func(s string) error {
+		sep = unescape(s)
+		return set()
+	})
+	return f
+}
+
+// unescape interprets Go escapes like \n and \x00 in a flag value, returning it as is if it is not valid.
+func unescape(s string) string {
+	if unquoted, err := strconv.Unquote(`"` + s + `"`); err == nil {
+		return unquoted
+	}
+	return s
+}
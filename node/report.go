@@ -0,0 +1,107 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ReportFdEnv is the environment variable the runtime sets to the file descriptor a node writes its events to.
+const ReportFdEnv = "HOSER_REPORT_FD"
+
+type EventKind string
+
+const (
+	EventLog      EventKind = "log"
+	EventError    EventKind = "error"
+	EventProgress EventKind = "progress"
+)
+
+// Event is a single structured report from a node to the runtime. Events are written as JSON lines.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Node    string    `json:"node"`
+	Kind    EventKind `json:"kind"`
+	Msg     string    `json:"msg,omitempty"`
+	Records int64     `json:"records,omitempty"` // Total records processed so far (progress only)
+	Bytes   int64     `json:"bytes,omitempty"`   // Total bytes processed so far (progress only)
+	Queued  int64     `json:"queued,omitempty"`  // Records read but not written yet, for nodes that buffer (progress only)
+}
+
+// progressInterval limits how often progress events are sent.
+const progressInterval = 500 * time.Millisecond
+
+type reporter struct {
+	name         string
+	mu           sync.Mutex
+	enc          *json.Encoder // nil if not running under a runtime that listens for events
+	lastProgress time.Time
+}
+
+func newReporter(name string) *reporter {
+	r := &reporter{name: name}
+	fdStr := os.Getenv(ReportFdEnv)
+	// The fd is only for this node, not for the programs it starts
+	os.Unsetenv(ReportFdEnv)
+	if fdStr != "" {
+		if fd, err := strconv.Atoi(fdStr); err == nil {
+			r.enc = json.NewEncoder(os.NewFile(uintptr(fd), "hoser-report"))
+		}
+	}
+	return r
+}
+
+func (r *reporter) send(ev Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.enc == nil {
+		return
+	}
+	now := time.Now()
+	if ev.Kind == EventProgress {
+		if now.Sub(r.lastProgress) < progressInterval {
+			return
+		}
+		r.lastProgress = now
+	}
+	ev.Time = now
+	ev.Node = r.name
+	if err := r.enc.Encode(ev); err != nil {
+		r.enc = nil // the runtime stopped listening, stop reporting
+	}
+}
+
+// Logf writes a message to stderr and reports it to the runtime.
+func (n *Node) Logf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	log.Printf("%s: %s", n.Name, msg)
+	n.report.send(Event{Kind: EventLog, Msg: msg})
+}
+
+// Errorf writes an error to stderr and reports it to the runtime. The node keeps running.
+func (n *Node) Errorf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	log.Printf("%s: error: %s", n.Name, msg)
+	n.report.send(Event{Kind: EventError, Msg: msg})
+}
+
+// Fatalf reports an error like Errorf and exits with code 1.
+func (n *Node) Fatalf(format string, args ...any) {
+	n.Errorf(format, args...)
+	os.Exit(1)
+}
+
+// Progress reports the total number of records and bytes processed so far to the runtime. It is not written to
+// stderr and at most one event is sent every progressInterval, so it is cheap to call for every record.
+func (n *Node) Progress(records, bytes int64) {
+	n.report.send(Event{Kind: EventProgress, Records: records, Bytes: bytes})
+}
+
+// ProgressQueued is Progress for nodes that buffer records, also reporting how many records are queued.
+func (n *Node) ProgressQueued(records, bytes, queued int64) {
+	n.report.send(Event{Kind: EventProgress, Records: records, Bytes: bytes, Queued: queued})
+}
@@ -0,0 +1,60 @@
+package osruntime
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/masp/hoser-runtime/builtin"
+	"github.com/masp/hoser-runtime/plan"
+)
+
+// insertBuffers replaces every link that has a buffer with a builtin:buffer process between its source and its
+// destination, named after the destination port (e.g. "grep0.stdin.buffer"). Neither side of the link needs to
+// know about the buffer. The pipe passed in is not modified.
+func insertBuffers(pipe plan.Pipe) (plan.Pipe, error) {
+	out := pipe
+	out.Procs = append([]plan.Process(nil), pipe.Procs...)
+	out.Links = make([]plan.Link, 0, len(pipe.Links))
+	inserted := false
+	for _, link := range pipe.Links {
+		if link.Buffer == 0 {
+			out.Links = append(out.Links, link)
+			continue
+		}
+		if link.Buffer < 0 {
+			return pipe, fmt.Errorf("link %s -> %s has a negative buffer", link.Src, link.Dst)
+		}
+		var srcPort *plan.Port
+		if src := pipe.FindProc(link.Src.Node); src != nil {
+			srcPort, _ = src.FindPort(link.Src.Port)
+		} else if src := pipe.FindVar(link.Src.Node); src != nil {
+			srcPort, _ = src.FindPort(link.Src.Port)
+		}
+		if srcPort == nil || srcPort.Type != plan.TypeStream {
+			return pipe, fmt.Errorf("link %s -> %s has a buffer but is not a stream link", link.Src, link.Dst)
+		}
+
+		name := fmt.Sprintf("%s.%s.buffer", link.Dst.Node, link.Dst.Port)
+		if pipe.FindProc(name) != nil || pipe.FindVar(name) != nil {
+			return pipe, fmt.Errorf("cannot buffer link %s -> %s, '%s' already exists", link.Src, link.Dst, name)
+		}
+		mem, size := plan.ArgString("-mem"), plan.ArgString(strconv.FormatInt(link.Buffer, 10))
+		out.Procs = append(out.Procs, plan.Process{
+			Node: plan.Node{
+				Name: name,
+				In:   []plan.Port{{Name: "stdin", Type: plan.TypeStream}},
+				Out:  []plan.Port{{Name: "stdout", Type: plan.TypeStream}},
+			},
+			Exe:  builtin.Prefix + "buffer",
+			Args: []plan.Arg{&mem, &size},
+		})
+		out.Links = append(out.Links,
+			plan.Link{Src: link.Src, Dst: plan.Ref{Node: name, Port: "stdin"}},
+			plan.Link{Src: plan.Ref{Node: name, Port: "stdout"}, Dst: link.Dst})
+		inserted = true
+	}
+	if inserted {
+		out.Sort()
+	}
+	return out, nil
+}
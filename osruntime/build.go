@@ -7,6 +7,9 @@ import (
 	"os/exec"
 	"strings"
 
+	"github.com/masp/hoser-runtime/backend"
+	"github.com/masp/hoser-runtime/builtin"
+	"github.com/masp/hoser-runtime/node"
 	"github.com/masp/hoser-runtime/plan"
 )
 
@@ -14,17 +17,51 @@ import (
 // running in parallel.
 //
 // To build a program from a pipe, we incrementally build from the bottom up over a series of passes.
-// 1. Take each process and create a OS process to match (runtime.Process)
-// 2. Build the connections between each OS process
-// 3. Build the connections between each OS process and variables (like stdin/stdout).
+// 1. Replace links that have a buffer with a builtin:buffer process (see insertBuffers)
+// 2. Check the ports of each node against the manifest of its executable (see checkManifests)
+// 3. Take each process and create a OS process to match (runtime.Process)
+// 4. Build the connections between each OS process
+// 5. Build the connections between each OS process and variables (like stdin/stdout).
+
+func init() {
+	backend.Register("os", Runtime{})
+}
+
+// Runtime is the backend.Runtime that runs every process of a plan as an OS process.
+type Runtime struct {
+	// NoCheck skips running the nodes with --hoser-describe to check their ports, e.g. for a dry run.
+	NoCheck bool
+}
+
+func (r Runtime) Build(pipe plan.Pipe, varPresets map[string]any) (backend.Program, error) {
+	prog, err := build(pipe, varPresets, !r.NoCheck)
+	if err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
 
 func Build(program plan.Pipe, varPresets map[string]any) (*Program, error) {
-	var err error
+	return build(program, varPresets, true)
+}
+
+func build(program plan.Pipe, varPresets map[string]any, check bool) (*Program, error) {
+	program, err := insertBuffers(program)
+	if err != nil {
+		return nil, err
+	}
+	if check {
+		err = checkManifests(program)
+		if err != nil {
+			return nil, err
+		}
+	}
 	rt := &Program{
 		procs: make(map[string]*Process),
 		vars:  make(map[string]*Variable),
-		ctx:   context.Background(),
+		stats: make(map[string]*backend.ProcStats),
 	}
+	rt.ctx, rt.cancel = context.WithCancel(context.Background())
 	for _, proc := range program.Procs {
 		rt.createProcess(proc)
 	}
@@ -89,6 +126,7 @@ func (rt *Program) createProcess(template plan.Process) *Process {
 		Links: make(map[string]*Link),
 	}
 	rt.procs[template.Name] = p
+	rt.stats[template.Name] = &backend.ProcStats{Name: template.Name, State: backend.StateCreated}
 	return p
 }
 
@@ -103,7 +141,7 @@ func (rt *Program) connectProc(prog plan.Pipe, dstProc *Process) error {
 	for _, in := range dst.In {
 		link := prog.FindLink(plan.Ref{Node: dst.Name, Port: in.Name})
 		if link == nil {
-			return nil
+			continue
 		}
 		linkInst := Link{Type: in.Type, Src: link.Src, Dst: link.Dst}
 		dstProc.Links[in.Name] = &linkInst
@@ -192,24 +230,39 @@ func bindDefaults(v *Variable) error {
 }
 
 // buildCmd creates an exec.Cmd that is executable for each process. The processes can be started in any order.
+//
+// Stream ports named stdin, stdout and stderr are connected to the matching stdio of the process. Any other stream
+// port passed as an argument is inherited as an extra file descriptor and passed as /dev/fd/N.
 func buildCmd(p *Process) (cmd *exec.Cmd, err error) {
+	p.Fds = map[string]int{"stdin": 0, "stdout": 1, "stderr": 2}
+	var extraFiles []*os.File
+	passFile := func(port string, fd *os.File) string {
+		n := 3 + len(extraFiles)
+		extraFiles = append(extraFiles, fd)
+		p.Fds[port] = n
+		return fmt.Sprintf("/dev/fd/%d", n)
+	}
+
 	args := make([]string, 0, len(p.Plan.Args))
 	for _, arg := range p.Plan.Args {
 		switch v := arg.(type) {
 		case *plan.Port:
 			_, dir := p.Plan.FindPort(v.Name)
 			link := p.Links[v.Name]
+			if link == nil {
+				return nil, fmt.Errorf("port '%s' of process '%s' is passed as an argument but not linked", v.Name, p.Plan.Name)
+			}
 			if dir == plan.PortIn {
 				switch v.Type {
 				case plan.TypeStream:
-					args = append(args, link.Rd.Name())
+					args = append(args, passFile(v.Name, link.Rd))
 				case plan.TypeString:
 					args = append(args, link.Value.(string))
 				}
 			} else if dir == plan.PortOut {
 				switch v.Type {
 				case plan.TypeStream:
-					args = append(args, link.Wr.Name())
+					args = append(args, passFile(v.Name, link.Wr))
 				default:
 					panic("unsupported type " + v.Type)
 				}
@@ -220,13 +273,34 @@ func buildCmd(p *Process) (cmd *exec.Cmd, err error) {
 	}
 
 	exe := p.Plan.Exe
-	if exe == "hoser" {
+	if builtin.IsBuiltin(exe) {
+		fn, ok := builtin.Lookup(exe)
+		if !ok {
+			return nil, fmt.Errorf("process '%s' uses unknown builtin '%s' (available: %v)", p.Plan.Name, exe, builtin.Names())
+		}
+		p.Builtin = fn
+		// The command of a builtin is never started, it only describes the invocation (see Process.runBuiltin)
+		cmd = &exec.Cmd{Path: exe, Args: append([]string{exe}, args...)}
+	} else if exe == "hoser" {
 		exe, err = os.Executable()
 		if err != nil {
 			exe = "hoser"
 		}
 	}
-	cmd = exec.Command(exe, args...)
+	if cmd == nil {
+		cmd = exec.Command(exe, args...)
+	}
+	if p.Plan.IsNode && p.Builtin == nil {
+		// Nodes written with package node report errors and progress over an extra pipe. Other programs don't
+		// get one, it would leak into everything they start.
+		p.reportRd, p.reportWr, err = os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		extraFiles = append(extraFiles, p.reportWr)
+		cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d", node.ReportFdEnv, 2+len(extraFiles)))
+	}
+	cmd.ExtraFiles = extraFiles
 	if link := p.Links["stdin"]; link != nil {
 		cmd.Stdin = link.Rd
 	}
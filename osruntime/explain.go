@@ -0,0 +1,245 @@
+package osruntime
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/masp/hoser-runtime/plan"
+)
+
+// Explanation describes everything a built Program would do when started: the exact commands, how each port is
+// mapped and how the variables are bound. It is produced without starting any process (see hoser -n).
+type Explanation struct {
+	Procs []ProcExplanation `json:"procs"`
+	Vars  []VarExplanation  `json:"vars"`
+	Links []LinkExplanation `json:"links"`
+}
+
+type ProcExplanation struct {
+	Name  string        `json:"name"`
+	Argv  []string      `json:"argv"`
+	Dir   string        `json:"dir"`
+	Env   []string      `json:"env"`
+	Ports []PortBinding `json:"ports"`
+}
+
+// PortBinding describes what a single port of a process is connected to.
+type PortBinding struct {
+	Port  string       `json:"port"`
+	Dir   string       `json:"dir"` // "in" or "out"
+	Type  plan.VarType `json:"type"`
+	Peer  string       `json:"peer,omitempty"`  // The node/port on the other side of the link
+	Fd    *int         `json:"fd,omitempty"`    // The fd inside the process (only for stream ports)
+	File  string       `json:"file,omitempty"`  // The file the fd refers to, "pipe" for anonymous pipes
+	Value string       `json:"value,omitempty"` // The value of the port for non-stream ports
+}
+
+type VarExplanation struct {
+	Name  string       `json:"name"`
+	Type  plan.VarType `json:"type"`
+	Value string       `json:"value"`
+}
+
+type LinkExplanation struct {
+	Src  string       `json:"src"`
+	Dst  string       `json:"dst"`
+	Type plan.VarType `json:"type"`
+	Kind string       `json:"kind"` // "pipe", "file" or "value"
+}
+
+// Explain describes the program without starting it.
+func (rt *Program) Explain() Explanation {
+	var ex Explanation
+	links := make(map[*Link]bool)
+	for _, name := range sortedKeys(rt.procs) {
+		proc := rt.procs[name]
+		pe := ProcExplanation{
+			Name: name,
+			Argv: proc.Cmd.Args,
+			Dir:  proc.Cmd.Dir,
+			Env:  proc.Cmd.Env,
+		}
+		if pe.Dir == "" {
+			pe.Dir, _ = os.Getwd()
+		}
+		if pe.Env == nil {
+			pe.Env = os.Environ()
+		}
+		for _, port := range proc.Plan.In {
+			pe.Ports = append(pe.Ports, explainPort(proc, port, "in"))
+		}
+		for _, port := range proc.Plan.Out {
+			pe.Ports = append(pe.Ports, explainPort(proc, port, "out"))
+		}
+		for _, link := range proc.Links {
+			links[link] = true
+		}
+		ex.Procs = append(ex.Procs, pe)
+	}
+
+	for _, name := range sortedKeys(rt.vars) {
+		vr := rt.vars[name]
+		ex.Vars = append(ex.Vars, VarExplanation{Name: name, Type: vr.Plan.Type(), Value: describeValue(vr.Value)})
+		for _, link := range []*Link{vr.In, vr.Out} {
+			if link != nil {
+				links[link] = true
+			}
+		}
+	}
+
+	for link := range links {
+		le := LinkExplanation{Src: link.Src.String(), Dst: link.Dst.String(), Type: link.Type, Kind: "value"}
+		if link.Type == plan.TypeStream {
+			le.Kind = "file"
+			if _, ok := rt.procs[link.Src.Node]; ok {
+				if _, ok := rt.procs[link.Dst.Node]; ok {
+					le.Kind = "pipe"
+				}
+			}
+		}
+		ex.Links = append(ex.Links, le)
+	}
+	sort.Slice(ex.Links, func(i, j int) bool {
+		if ex.Links[i].Src != ex.Links[j].Src {
+			return ex.Links[i].Src < ex.Links[j].Src
+		}
+		return ex.Links[i].Dst < ex.Links[j].Dst
+	})
+	return ex
+}
+
+func explainPort(proc *Process, port plan.Port, dir string) PortBinding {
+	pb := PortBinding{Port: port.Name, Dir: dir, Type: port.Type}
+	link := proc.Links[port.Name]
+	if link == nil {
+		return pb
+	}
+	if dir == "in" {
+		pb.Peer = link.Src.String()
+	} else {
+		pb.Peer = link.Dst.String()
+	}
+	if port.Type != plan.TypeStream {
+		pb.Value = describeValue(link.Value)
+		return pb
+	}
+
+	if fd, ok := proc.Fds[port.Name]; ok {
+		pb.Fd = &fd
+	}
+	file := link.Wr
+	if dir == "in" {
+		file = link.Rd
+	}
+	if file != nil {
+		pb.File = file.Name()
+		if strings.HasPrefix(pb.File, "|") { // os.Pipe names its ends |0 and |1
+			pb.File = "pipe"
+		}
+	}
+	return pb
+}
+
+func describeValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case *os.File:
+		return v.Name()
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteJSON writes the explanation as indented JSON.
+func (ex Explanation) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ex)
+}
+
+// WriteText writes the explanation in a human readable form. Only the environment variables that differ from the
+// environment of hoser itself are printed.
+func (ex Explanation) WriteText(w io.Writer) error {
+	parent := make(map[string]bool)
+	for _, kv := range os.Environ() {
+		parent[kv] = true
+	}
+
+	var b strings.Builder
+	for _, proc := range ex.Procs {
+		fmt.Fprintf(&b, "proc %s\n", proc.Name)
+		fmt.Fprintf(&b, "  argv: %s\n", quoteArgs(proc.Argv))
+		fmt.Fprintf(&b, "  dir:  %s\n", proc.Dir)
+		var changed []string
+		for _, kv := range proc.Env {
+			if !parent[kv] {
+				changed = append(changed, kv)
+			}
+		}
+		fmt.Fprintf(&b, "  env:  inherited (%d vars)\n", len(proc.Env)-len(changed))
+		for _, kv := range changed {
+			fmt.Fprintf(&b, "        %s\n", kv)
+		}
+		for _, port := range proc.Ports {
+			arrow := "<-"
+			if port.Dir == "out" {
+				arrow = "->"
+			}
+			peer := port.Peer
+			if peer == "" {
+				peer = "(unlinked)"
+			}
+			fmt.Fprintf(&b, "  %-3s %s %s %s %s", port.Dir, port.Port, port.Type, arrow, peer)
+			if port.Fd != nil {
+				fmt.Fprintf(&b, " fd %d", *port.Fd)
+			}
+			if port.File != "" {
+				fmt.Fprintf(&b, " (%s)", port.File)
+			}
+			if port.Type != plan.TypeStream && port.Peer != "" {
+				fmt.Fprintf(&b, " = %q", port.Value)
+			}
+			b.WriteString("\n")
+		}
+	}
+	for _, vr := range ex.Vars {
+		if vr.Type == plan.TypeString {
+			fmt.Fprintf(&b, "var %s %s = %q\n", vr.Name, vr.Type, vr.Value)
+		} else {
+			fmt.Fprintf(&b, "var %s %s = %s\n", vr.Name, vr.Type, vr.Value)
+		}
+	}
+	for _, link := range ex.Links {
+		fmt.Fprintf(&b, "link %s -> %s (%s, %s)\n", link.Src, link.Dst, link.Type, link.Kind)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$*?;|&<>()") {
+			quoted[i] = fmt.Sprintf("%q", arg)
+		} else {
+			quoted[i] = arg
+		}
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,106 @@
+package osruntime
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/masp/hoser-runtime/plan"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func buildExplained(t *testing.T, pipeJSON string) Explanation {
+	pipes, err := plan.Unmarshal(strings.NewReader(pipeJSON))
+	require.NoError(t, err)
+	dir := t.TempDir()
+	in, err := os.Create(filepath.Join(dir, "in"))
+	require.NoError(t, err)
+	out, err := os.Create(filepath.Join(dir, "out"))
+	require.NoError(t, err)
+	prog, err := Build(pipes[0], map[string]any{"stdin": in, "stdout": out})
+	require.NoError(t, err)
+	defer prog.Close()
+	return prog.Explain()
+}
+
+func intPtr(i int) *int { return &i }
+
+func TestExplain(t *testing.T) {
+	ex := buildExplained(t, teeMergePipe)
+	in, out := ex.Vars[0].Value, ex.Vars[1].Value
+	assert.Equal(t, "in", filepath.Base(in))
+	assert.Equal(t, "out", filepath.Base(out))
+	require.Len(t, ex.Procs, 2)
+	merge, tee := ex.Procs[0], ex.Procs[1]
+	assert.Equal(t, "merge0", merge.Name)
+	assert.Equal(t, []string{"builtin:merge", "/dev/fd/3", "/dev/fd/4"}, merge.Argv)
+	assert.Equal(t, []PortBinding{
+		{Port: "a", Dir: "in", Type: plan.TypeStream, Peer: "tee0/stdout", Fd: intPtr(3), File: "pipe"},
+		{Port: "b", Dir: "in", Type: plan.TypeStream, Peer: "tee0/copy", Fd: intPtr(4), File: "pipe"},
+		{Port: "stdout", Dir: "out", Type: plan.TypeStream, Peer: "stdout/i", Fd: intPtr(1), File: out},
+	}, merge.Ports)
+	assert.Equal(t, []string{"builtin:tee", "/dev/fd/3"}, tee.Argv)
+
+	assert.Equal(t, []VarExplanation{
+		{Name: "stdin", Type: plan.TypeStream, Value: in},
+		{Name: "stdout", Type: plan.TypeStream, Value: out},
+	}, ex.Vars)
+	var kinds []string
+	for _, link := range ex.Links {
+		kinds = append(kinds, link.Src+" -> "+link.Dst+" "+link.Kind)
+	}
+	assert.Equal(t, []string{
+		"merge0/stdout -> stdout/i file",
+		"stdin/o -> tee0/stdin file",
+		"tee0/copy -> merge0/b pipe",
+		"tee0/stdout -> merge0/a pipe",
+	}, kinds)
+
+	var buf bytes.Buffer
+	require.NoError(t, ex.WriteJSON(&buf))
+	var decoded Explanation
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, ex, decoded)
+
+	buf.Reset()
+	require.NoError(t, ex.WriteText(&buf))
+	text := buf.String()
+	assert.Contains(t, text, "proc merge0\n  argv: builtin:merge /dev/fd/3 /dev/fd/4\n")
+	assert.Contains(t, text, "  in  a stream <- tee0/stdout fd 3 (pipe)\n")
+	assert.Contains(t, text, "link tee0/copy -> merge0/b (stream, pipe)\n")
+}
+
+// TestExplainUnlinkedPort checks that an unlinked input does not keep the inputs after it from being connected.
+func TestExplainUnlinkedPort(t *testing.T) {
+	ex := buildExplained(t, `[{"name": "unlinked",
+	"procs": [
+		{"name": "cat0", "in": [{"name": "extra", "type": "stream"}, {"name": "stdin", "type": "stream"}],
+		 "out": [{"name": "stdout", "type": "stream"}], "exe": "cat", "args": []}
+	],
+	"vars": [
+		{"name": "stdin", "in": [{"name": "i", "type": "stream"}], "out": [{"name": "o", "type": "stream"}]},
+		{"name": "stdout", "in": [{"name": "i", "type": "stream"}], "out": [{"name": "o", "type": "stream"}]}
+	],
+	"links": [
+		{"src": {"node": "stdin", "port": "o"}, "dst": {"node": "cat0", "port": "stdin"}},
+		{"src": {"node": "cat0", "port": "stdout"}, "dst": {"node": "stdout", "port": "i"}}
+	]}]`)
+	require.Len(t, ex.Procs, 1)
+	ports := ex.Procs[0].Ports
+	require.Len(t, ports, 3)
+	assert.Equal(t, PortBinding{Port: "extra", Dir: "in", Type: plan.TypeStream}, ports[0])
+	assert.Equal(t, "stdin/o", ports[1].Peer)
+	assert.Equal(t, intPtr(0), ports[1].Fd)
+}
+
+func TestBuildUnlinkedArg(t *testing.T) {
+	pipes, err := plan.Unmarshal(strings.NewReader(`[{"name": "unlinked",
+	"procs": [{"name": "cat0", "in": [{"name": "file", "type": "stream"}], "exe": "cat", "args": [{"name": "file"}]}]}]`))
+	require.NoError(t, err)
+	_, err = Build(pipes[0], nil)
+	assert.ErrorContains(t, err, "port 'file' of process 'cat0' is passed as an argument but not linked")
+}
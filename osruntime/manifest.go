@@ -0,0 +1,99 @@
+package osruntime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/masp/hoser-runtime/builtin"
+	"github.com/masp/hoser-runtime/plan"
+)
+
+// describeTimeout bounds how long an executable may take to print its manifest.
+const describeTimeout = 5 * time.Second
+
+// describeCache holds the result of running each executable with --hoser-describe, so that an executable is only
+// asked once per hoser process no matter how many programs are built.
+var describeCache = struct {
+	sync.Mutex
+	results map[string]describeResult
+}{results: make(map[string]describeResult)}
+
+type describeResult struct {
+	m   plan.Manifest
+	err error
+}
+
+// Describe returns the manifest of an executable or builtin. Executables are run with --hoser-describe, the
+// result is cached.
+func Describe(exe string) (plan.Manifest, error) {
+	if builtin.IsBuiltin(exe) {
+		m, ok := builtin.Describe(exe)
+		if !ok {
+			return plan.Manifest{}, fmt.Errorf("unknown builtin '%s'", exe)
+		}
+		return m, nil
+	}
+
+	describeCache.Lock()
+	defer describeCache.Unlock()
+	if res, ok := describeCache.results[exe]; ok {
+		return res.m, res.err
+	}
+	m, err := describeExe(exe)
+	describeCache.results[exe] = describeResult{m, err}
+	return m, err
+}
+
+func describeExe(exe string) (plan.Manifest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), describeTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, exe, "--hoser-describe").Output()
+	if err != nil {
+		return plan.Manifest{}, fmt.Errorf("describe '%s': %w", exe, err)
+	}
+	var m plan.Manifest
+	if err := json.Unmarshal(out, &m); err != nil {
+		return plan.Manifest{}, fmt.Errorf("describe '%s': invalid manifest: %w", exe, err)
+	}
+	return m, nil
+}
+
+// isDescribable reports whether the manifest of a process should be checked at build time. Running an arbitrary
+// program with an unknown flag can have side effects, so only builtins and the processes the plan marks as nodes
+// are asked.
+func isDescribable(proc plan.Process) bool {
+	return builtin.IsBuiltin(proc.Exe) || proc.IsNode
+}
+
+// checkManifests verifies the ports of every process against the manifest of its executable. Executables that
+// fail to describe themselves are not checked.
+func checkManifests(program plan.Pipe) error {
+	manifests := make(map[string]*plan.Manifest)
+	for _, proc := range program.Procs {
+		if !isDescribable(proc) {
+			continue
+		}
+		m, ok := manifests[proc.Exe]
+		if !ok {
+			desc, err := Describe(proc.Exe)
+			if err != nil {
+				log.Printf("[%s] not checking ports: %v", proc.Name, err)
+			} else {
+				m = &desc
+			}
+			manifests[proc.Exe] = m
+		}
+		if m == nil {
+			continue
+		}
+		if err := m.Check(proc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
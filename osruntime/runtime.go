@@ -2,13 +2,21 @@ package osruntime
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
+	"github.com/masp/hoser-runtime/backend"
+	"github.com/masp/hoser-runtime/builtin"
+	"github.com/masp/hoser-runtime/node"
 	"github.com/masp/hoser-runtime/plan"
 )
 
@@ -24,25 +32,70 @@ import (
 type Process struct {
 	Plan  plan.Process
 	Links map[string]*Link // A mapping of all incoming and outgoing pipes by name
+	Fds   map[string]int   // The file descriptor each stream port is mapped to inside the process
 	Cmd   *exec.Cmd
+
+	Builtin builtin.Func // If set, the process runs in-process as a goroutine rather than executing Cmd
+
+	reportRd, reportWr *os.File // The pipe the process reports node.Events over (only if Plan.IsNode)
+}
+
+// runBuiltin runs a builtin process with the same stdio and extra files an OS process running Cmd would get.
+func (p *Process) runBuiltin(ctx context.Context, report func(node.Event)) int {
+	env := &builtin.Env{
+		Name:       strings.TrimPrefix(p.Plan.Exe, builtin.Prefix),
+		Args:       p.Cmd.Args[1:],
+		Stdin:      p.Cmd.Stdin,
+		Stdout:     p.Cmd.Stdout,
+		Stderr:     p.Cmd.Stderr,
+		ExtraFiles: p.Cmd.ExtraFiles,
+		Report:     report,
+	}
+	if env.Stdin == nil {
+		env.Stdin = strings.NewReader("")
+	}
+	if env.Stdout == nil {
+		env.Stdout = io.Discard
+	}
+	if env.Stderr == nil {
+		env.Stderr = io.Discard
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Builtin(ctx, env)
+	}()
+	var err error
+	select {
+	case <-ctx.Done():
+		p.Close() // unblock any reads or writes on the ports
+		err = <-done
+	case err = <-done:
+	}
+	if err != nil {
+		env.Logf("%v", err)
+		return 1
+	}
+	return 0
 }
 
 func (p *Process) Close() error {
+	var firstErr error
 	for _, link := range p.Links {
 		if link.Rd != nil && link.Dst.Node == p.Plan.Name {
 			err := link.Rd.Close()
-			if err != nil {
-				return err
+			if err != nil && !errors.Is(err, os.ErrClosed) && firstErr == nil {
+				firstErr = err
 			}
 		}
 		if link.Wr != nil && link.Src.Node == p.Plan.Name {
 			err := link.Wr.Close()
-			if err != nil {
-				return err
+			if err != nil && !errors.Is(err, os.ErrClosed) && firstErr == nil {
+				firstErr = err
 			}
 		}
 	}
-	return nil
+	return firstErr
 }
 
 type Variable struct {
@@ -91,12 +144,20 @@ type Link struct {
 	Value any      // The value of this link if constant (not a stream link, e.g. string)
 }
 
+// KillTimeout is how long a process has to exit after SIGTERM before it is killed.
+var KillTimeout = 10 * time.Second
+
 // Program is a set of processes that are scheduled and executed by the appropriate OS resources.
 type Program struct {
-	procs map[string]*Process
-	vars  map[string]*Variable
-	ctx   context.Context
-	wg    *sync.WaitGroup
+	procs  map[string]*Process
+	vars   map[string]*Variable
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     *sync.WaitGroup
+
+	mu       sync.Mutex // protects stats and failures
+	stats    map[string]*backend.ProcStats
+	failures []string // The processes that failed, in the order they exited
 }
 
 func (rt *Program) Start() error {
@@ -109,27 +170,59 @@ func (rt *Program) Start() error {
 		go func(proc *Process) {
 			defer rt.wg.Done()
 			defer proc.Close()
-			exited := make(chan int)
-			go func() {
-				log.Printf("[%s] start: %s {%s}", proc.Plan.Name, strings.Join(proc.Cmd.Args, " "), procInfo(proc))
-				err := proc.Cmd.Run()
-				if exitErr, ok := err.(*exec.ExitError); ok {
-					exited <- exitErr.ExitCode()
-				} else if err != nil {
-					log.Printf("[%s] start failed: %v'", proc.Plan.Name, err)
-					exited <- 1
-				} else {
-					exited <- 0
+			log.Printf("[%s] start: %s {%s}", proc.Plan.Name, strings.Join(proc.Cmd.Args, " "), procInfo(proc))
+			if proc.Builtin != nil {
+				rt.setRunning(proc)
+				rc := proc.runBuiltin(rt.ctx, func(ev node.Event) { rt.report(proc, ev) })
+				log.Printf("[%s] exited: %d", proc.Plan.Name, rc)
+				rt.setExited(proc, rc)
+				return
+			}
+			err := proc.Cmd.Start()
+			if proc.reportWr != nil {
+				proc.reportWr.Close()
+			}
+			if err != nil {
+				log.Printf("[%s] start failed: %v'", proc.Plan.Name, err)
+				if proc.reportRd != nil {
+					proc.reportRd.Close()
 				}
-				close(exited)
-			}()
+				rt.setExited(proc, 1)
+				return
+			}
+			rt.setRunning(proc)
+			if proc.reportRd != nil {
+				go rt.readReports(proc)
+			}
 
+			exited := make(chan error, 1)
+			go func() {
+				exited <- proc.Cmd.Wait()
+			}()
 			select {
 			case <-rt.ctx.Done():
-				return
-			case rc := <-exited:
-				log.Printf("[%s] exited: %d", proc.Plan.Name, rc)
+				proc.Cmd.Process.Signal(syscall.SIGTERM)
+				timer := time.NewTimer(KillTimeout)
+				select {
+				case err = <-exited:
+				case <-timer.C:
+					log.Printf("[%s] still running %s after SIGTERM, killing it", proc.Plan.Name, KillTimeout)
+					proc.Cmd.Process.Kill()
+					err = <-exited
+				}
+				timer.Stop()
+			case err = <-exited:
+			}
+
+			rc := 0
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				rc = exitErr.ExitCode()
+			} else if err != nil {
+				log.Printf("[%s] wait failed: %v", proc.Plan.Name, err)
+				rc = 1
 			}
+			log.Printf("[%s] exited: %d", proc.Plan.Name, rc)
+			rt.setExited(proc, rc)
 		}(proc)
 	}
 	return nil
@@ -149,9 +242,110 @@ func procInfo(proc *Process) string {
 	return strings.Join(info, ", ")
 }
 
-func (rt *Program) Wait() {
+func (rt *Program) Wait() error {
 	if rt.wg == nil {
 		panic("Start() never called")
 	}
 	rt.wg.Wait()
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if len(rt.failures) == 0 {
+		return nil
+	}
+	// The first failure is usually the cause, the processes after it fail because their peer is gone
+	stat := rt.stats[rt.failures[0]]
+	return fmt.Errorf("process '%s' failed with exit code %d", stat.Name, stat.ExitCode)
+}
+
+// Close releases the pipes of a program that was built but never started, e.g. to only Explain it.
+func (rt *Program) Close() error {
+	if rt.wg != nil {
+		panic("Close() called after Start()")
+	}
+	var firstErr error
+	for _, proc := range rt.procs {
+		if err := proc.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if proc.reportRd != nil {
+			proc.reportRd.Close()
+			proc.reportWr.Close()
+		}
+	}
+	rt.cancel()
+	return firstErr
+}
+
+// Stop sends SIGTERM to every running process, and SIGKILL to those still running KillTimeout later.
+func (rt *Program) Stop() error {
+	rt.cancel()
+	return nil
+}
+
+func (rt *Program) Stats() []backend.ProcStats {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	stats := make([]backend.ProcStats, 0, len(rt.stats))
+	for _, name := range sortedKeys(rt.stats) {
+		stats = append(stats, *rt.stats[name])
+	}
+	return stats
+}
+
+func (rt *Program) setRunning(proc *Process) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	stat := rt.stats[proc.Plan.Name]
+	stat.State = backend.StateRunning
+	if proc.Cmd.Process != nil {
+		stat.Pid = proc.Cmd.Process.Pid
+	}
+	stat.Started = time.Now()
+}
+
+// readReports reads the events a process reports until it exits and records them in its stats.
+func (rt *Program) readReports(proc *Process) {
+	defer proc.reportRd.Close()
+	dec := json.NewDecoder(proc.reportRd)
+	for {
+		var ev node.Event
+		if err := dec.Decode(&ev); err != nil {
+			if err != io.EOF {
+				log.Printf("[%s] bad report: %v", proc.Plan.Name, err)
+			}
+			return
+		}
+
+		rt.report(proc, ev)
+	}
+}
+
+// report records an event of a process in its stats.
+func (rt *Program) report(proc *Process, ev node.Event) {
+	rt.mu.Lock()
+	stat := rt.stats[proc.Plan.Name]
+	switch ev.Kind {
+	case node.EventProgress:
+		stat.Records, stat.Bytes, stat.Queued = ev.Records, ev.Bytes, ev.Queued
+	case node.EventError:
+		stat.Errors++
+		stat.LastError = ev.Msg
+	}
+	rt.mu.Unlock()
+	if ev.Kind != node.EventProgress {
+		log.Printf("[%s] %s: %s", proc.Plan.Name, ev.Kind, ev.Msg)
+	}
+}
+
+func (rt *Program) setExited(proc *Process, rc int) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	stat := rt.stats[proc.Plan.Name]
+	stat.State = backend.StateExited
+	if rc != 0 {
+		stat.State = backend.StateFailed
+		rt.failures = append(rt.failures, stat.Name)
+	}
+	stat.ExitCode = rc
+	stat.Exited = time.Now()
 }
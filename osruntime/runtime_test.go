@@ -0,0 +1,206 @@
+package osruntime
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/masp/hoser-runtime/backend"
+	"github.com/masp/hoser-runtime/plan"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const teeMergePipe = `[{"name": "teemerge",
+	"procs": [
+		{"name": "tee0", "in": [{"name": "stdin", "type": "stream"}],
+		 "out": [{"name": "stdout", "type": "stream"}, {"name": "copy", "type": "stream"}],
+		 "exe": "builtin:tee", "args": [{"name": "copy"}]},
+		{"name": "merge0", "in": [{"name": "a", "type": "stream"}, {"name": "b", "type": "stream"}],
+		 "out": [{"name": "stdout", "type": "stream"}],
+		 "exe": "builtin:merge", "args": [{"name": "a"}, {"name": "b"}]}
+	],
+	"vars": [
+		{"name": "stdin", "in": [{"name": "i", "type": "stream"}], "out": [{"name": "o", "type": "stream"}]},
+		{"name": "stdout", "in": [{"name": "i", "type": "stream"}], "out": [{"name": "o", "type": "stream"}]}
+	],
+	"links": [
+		{"src": {"node": "stdin", "port": "o"}, "dst": {"node": "tee0", "port": "stdin"}},
+		{"src": {"node": "tee0", "port": "stdout"}, "dst": {"node": "merge0", "port": "a"}},
+		{"src": {"node": "tee0", "port": "copy"}, "dst": {"node": "merge0", "port": "b"}},
+		{"src": {"node": "merge0", "port": "stdout"}, "dst": {"node": "stdout", "port": "i"}}
+	]}]`
+
+func TestBuiltins(t *testing.T) {
+	pipes, err := plan.Unmarshal(strings.NewReader(teeMergePipe))
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	inPath, outPath := filepath.Join(dir, "in"), filepath.Join(dir, "out")
+	require.NoError(t, os.WriteFile(inPath, []byte("a\nb\nc"), 0o644))
+	in, err := os.Open(inPath)
+	require.NoError(t, err)
+	out, err := os.Create(outPath)
+	require.NoError(t, err)
+
+	prog, err := Build(pipes[0], map[string]any{"stdin": in, "stdout": out})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"builtin:merge", "/dev/fd/3", "/dev/fd/4"}, prog.Explain().Procs[0].Argv)
+
+	require.NoError(t, prog.Start())
+	require.NoError(t, prog.Wait())
+	for _, stat := range prog.Stats() {
+		assert.Equal(t, backend.StateExited, stat.State, stat.Name)
+	}
+
+	got, err := os.ReadFile(outPath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(got), "\n"), "\n")
+	sort.Strings(lines)
+	assert.Equal(t, []string{"a", "a", "b", "b", "c", "c"}, lines)
+}
+
+func TestUnknownBuiltin(t *testing.T) {
+	pipe := plan.Pipe{Procs: []plan.Process{{Node: plan.Node{Name: "p"}, Exe: "builtin:nope"}}}
+	_, err := Build(pipe, nil)
+	assert.ErrorContains(t, err, "unknown builtin")
+}
+
+func TestBufferedLink(t *testing.T) {
+	pipes, err := plan.Unmarshal(strings.NewReader(`[{"name": "buffered",
+	"procs": [
+		{"name": "tee0", "in": [{"name": "stdin", "type": "stream"}], "out": [{"name": "stdout", "type": "stream"}],
+		 "exe": "builtin:tee", "args": []}
+	],
+	"vars": [
+		{"name": "stdin", "in": [{"name": "i", "type": "stream"}], "out": [{"name": "o", "type": "stream"}]},
+		{"name": "stdout", "in": [{"name": "i", "type": "stream"}], "out": [{"name": "o", "type": "stream"}]}
+	],
+	"links": [
+		{"src": {"node": "stdin", "port": "o"}, "dst": {"node": "tee0", "port": "stdin"}, "buffer": 4},
+		{"src": {"node": "tee0", "port": "stdout"}, "dst": {"node": "stdout", "port": "i"}}
+	]}]`))
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	inPath, outPath := filepath.Join(dir, "in"), filepath.Join(dir, "out")
+	require.NoError(t, os.WriteFile(inPath, []byte("a\nbb\nccc\n"), 0o644))
+	in, err := os.Open(inPath)
+	require.NoError(t, err)
+	out, err := os.Create(outPath)
+	require.NoError(t, err)
+
+	prog, err := Build(pipes[0], map[string]any{"stdin": in, "stdout": out})
+	require.NoError(t, err)
+	require.NoError(t, prog.Start())
+	require.NoError(t, prog.Wait())
+
+	stats := prog.Stats()
+	require.Len(t, stats, 2)
+	assert.Equal(t, "tee0.stdin.buffer", stats[1].Name)
+	assert.Equal(t, int64(3), stats[1].Records)
+	got, err := os.ReadFile(outPath)
+	require.NoError(t, err)
+	assert.Equal(t, "a\nbb\nccc\n", string(got))
+}
+
+func TestStopKills(t *testing.T) {
+	pipes, err := plan.Unmarshal(strings.NewReader(`[{"name": "stubborn",
+	"procs": [{"name": "sh0", "exe": "sh", "args": ["-c", "trap '' TERM; while :; do :; done"]}]}]`))
+	require.NoError(t, err)
+	defer func(timeout time.Duration) { KillTimeout = timeout }(KillTimeout)
+	KillTimeout = 100 * time.Millisecond
+
+	prog, err := Build(pipes[0], nil)
+	require.NoError(t, err)
+	require.NoError(t, prog.Start())
+	time.Sleep(100 * time.Millisecond) // let sh set up the trap
+	start := time.Now()
+	require.NoError(t, prog.Stop())
+	assert.Error(t, prog.Wait())
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+// writeNode writes a script that answers --hoser-describe like a node with a stdin and stdout, appending a line to
+// calls every time it is described.
+func writeNode(t *testing.T, name string) (exe, calls string) {
+	dir := t.TempDir()
+	exe, calls = filepath.Join(dir, name), filepath.Join(dir, "calls")
+	manifest := `{"name": "` + name + `", "in": [{"name": "stdin", "type": "stream"}], "out": [{"name": "stdout", "type": "stream"}]}`
+	script := "#!/bin/sh\necho >> " + calls + "\necho '" + manifest + "'\n"
+	require.NoError(t, os.WriteFile(exe, []byte(script), 0o755))
+	return exe, calls
+}
+
+// TestNode checks that only the processes marked as nodes are described and get a report pipe, whatever their name.
+func TestNode(t *testing.T) {
+	exe, calls := writeNode(t, "wordcount")
+	pipe := plan.Pipe{Procs: []plan.Process{
+		{Node: plan.Node{Name: "node", In: []plan.Port{{Name: "stdin", Type: plan.TypeStream}}}, Exe: exe, IsNode: true},
+		{Node: plan.Node{Name: "other"}, Exe: "sh"},
+	}}
+
+	prog, err := Build(pipe, nil)
+	require.NoError(t, err)
+	defer prog.Close()
+	node, other := prog.procs["node"], prog.procs["other"]
+	assert.Contains(t, node.Cmd.Env, "HOSER_REPORT_FD=3")
+	assert.Len(t, node.Cmd.ExtraFiles, 1)
+	assert.Nil(t, other.Cmd.Env)
+	assert.Empty(t, other.Cmd.ExtraFiles)
+	assert.Nil(t, other.reportRd)
+	assert.FileExists(t, calls)
+
+	pipe.Procs[0].In[0].Type = plan.TypeString
+	_, err = Build(pipe, nil)
+	assert.ErrorContains(t, err, "port 'stdin'", "the node is checked against its manifest")
+
+	exe, calls = writeNode(t, "hoser-unmarked")
+	pipes, err := plan.Unmarshal(strings.NewReader(`[{"name": "unmarked", "procs": [{"name": "p", "exe": "` + exe + `"}]}]`))
+	require.NoError(t, err)
+	prog, err = Build(pipes[0], nil)
+	require.NoError(t, err)
+	defer prog.Close()
+	assert.NoFileExists(t, calls, "a process not marked as a node is never described")
+	assert.Nil(t, prog.procs["p"].reportRd)
+}
+
+func TestDescribeOnce(t *testing.T) {
+	exe, calls := writeNode(t, "counted")
+	pipes, err := plan.Unmarshal(strings.NewReader(`[{"name": "twice", "procs": [
+		{"name": "a", "exe": "` + exe + `", "node": true},
+		{"name": "b", "exe": "` + exe + `", "node": true}
+	]}]`))
+	require.NoError(t, err)
+	require.True(t, pipes[0].Procs[0].IsNode)
+
+	prog, err := Runtime{NoCheck: true}.Build(pipes[0], nil)
+	require.NoError(t, err)
+	prog.(*Program).Close()
+	assert.NoFileExists(t, calls)
+
+	for i := 0; i < 2; i++ {
+		prog, err := Build(pipes[0], nil)
+		require.NoError(t, err)
+		prog.Close()
+	}
+	got, err := os.ReadFile(calls)
+	require.NoError(t, err)
+	assert.Equal(t, "\n", string(got))
+}
+
+func TestWaitFirstFailure(t *testing.T) {
+	pipes, err := plan.Unmarshal(strings.NewReader(`[{"name": "failing",
+	"procs": [
+		{"name": "a", "exe": "sh", "args": ["-c", "sleep 0.2; exit 1"]},
+		{"name": "b", "exe": "sh", "args": ["-c", "exit 2"]}
+	]}]`))
+	require.NoError(t, err)
+	prog, err := Build(pipes[0], nil)
+	require.NoError(t, err)
+	require.NoError(t, prog.Start())
+	assert.EqualError(t, prog.Wait(), "process 'b' failed with exit code 2")
+}
@@ -0,0 +1,148 @@
+package plan
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Manifest is how an executable describes itself (printed as JSON with --hoser-describe): the ports it has, which
+// of them are passed as positional arguments and the flags it accepts.
+type Manifest struct {
+	Name  string     `json:"name"`
+	In    []Port     `json:"in"`
+	Out   []Port     `json:"out"`
+	Args  []ArgSpec  `json:"args"`
+	Flags []FlagSpec `json:"flags"`
+}
+
+// ArgSpec is a positional argument bound to a port of the manifest.
+type ArgSpec struct {
+	Name     string `json:"name"`
+	Variadic bool   `json:"variadic,omitempty"` // The port takes all the remaining positional arguments
+}
+
+// FlagSpec describes a single flag.
+type FlagSpec struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"` // e.g. bool, string, int, duration
+	Default string `json:"default,omitempty"`
+	Usage   string `json:"usage,omitempty"`
+}
+
+func isStdio(name string) bool {
+	return name == "stdin" || name == "stdout" || name == "stderr"
+}
+
+func (m Manifest) findPort(name string) (*Port, PortDir) {
+	return Node{In: m.In, Out: m.Out}.FindPort(name)
+}
+
+// Check verifies that the ports of proc are ones the executable actually has. Stdio ports must be declared by the
+// manifest with the same name (except stderr, which every process has), while stream ports passed as arguments
+// are matched by position with the manifest's stream Args and must have the same direction and type. Ports passed
+// as the value of a flag, and string ports, are not matched.
+func (m Manifest) Check(proc Process) error {
+	var problems []string
+	for _, ports := range [][]Port{proc.In, proc.Out} {
+		for _, port := range ports {
+			if !isStdio(port.Name) {
+				continue
+			}
+			_, planDir := proc.FindPort(port.Name)
+			mport, mdir := m.findPort(port.Name)
+			if mport == nil {
+				if port.Name != "stderr" {
+					problems = append(problems, fmt.Sprintf("port '%s' is not used by %s", port.Name, m.Name))
+				}
+				continue
+			}
+			if mdir != planDir || mport.Type != port.Type {
+				problems = append(problems, fmt.Sprintf("port '%s' is %s %s, %s expects %s %s",
+					port.Name, planDir, port.Type, m.Name, mdir, mport.Type))
+			}
+		}
+	}
+
+	// Only stream ports are matched by position: a string port may as well be the value of a flag
+	var streamArgs []ArgSpec
+	for _, spec := range m.Args {
+		if mport, _ := m.findPort(spec.Name); mport == nil || mport.Type == TypeStream {
+			streamArgs = append(streamArgs, spec)
+		}
+	}
+	passed := make(map[string]bool)
+	pos := 0
+	flagValue := false // The argument is the value of the flag before it
+	for _, arg := range proc.Args {
+		port, ok := arg.(*Port)
+		if !ok {
+			flagValue = false
+			if s, ok := arg.(*ArgString); ok {
+				flagValue = m.takesValue(string(*s))
+			}
+			continue
+		}
+		passed[port.Name] = true
+		if flagValue || port.Type != TypeStream {
+			flagValue = false
+			continue
+		}
+		if pos >= len(streamArgs) {
+			problems = append(problems, fmt.Sprintf("port '%s' is passed as an extra argument, %s takes %d", port.Name, m.Name, len(streamArgs)))
+			continue
+		}
+		spec := streamArgs[pos]
+		if !spec.Variadic {
+			pos++
+		}
+		_, planDir := proc.FindPort(port.Name)
+		mport, mdir := m.findPort(spec.Name)
+		if mport == nil {
+			problems = append(problems, fmt.Sprintf("manifest of %s has argument '%s' without a port", m.Name, spec.Name))
+			continue
+		}
+		if mdir != planDir || mport.Type != port.Type {
+			problems = append(problems, fmt.Sprintf("port '%s' is passed as '%s' which is %s %s, plan has %s %s",
+				port.Name, spec.Name, mdir, mport.Type, planDir, port.Type))
+		}
+	}
+
+	for _, ports := range [][]Port{proc.In, proc.Out} {
+		for _, port := range ports {
+			if !isStdio(port.Name) && !passed[port.Name] {
+				problems = append(problems, fmt.Sprintf("port '%s' is neither stdio nor passed as an argument", port.Name))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("process '%s' does not match manifest of %s: %s", proc.Name, m.Name, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// takesValue reports whether arg is a flag of the manifest that takes the next argument as its value, like -sep in
+// "-sep ,". Boolean flags and flags given as -name=value do not.
+func (m Manifest) takesValue(arg string) bool {
+	if !strings.HasPrefix(arg, "-") || strings.Contains(arg, "=") {
+		return false
+	}
+	name := strings.TrimLeft(arg, "-")
+	for _, f := range m.Flags {
+		if f.Name == name {
+			return f.Type != "bool"
+		}
+	}
+	return false
+}
+
+func (d PortDir) String() string {
+	switch d {
+	case PortIn:
+		return "in"
+	case PortOut:
+		return "out"
+	default:
+		return "none"
+	}
+}
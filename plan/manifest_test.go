@@ -0,0 +1,39 @@
+package plan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifestCheck(t *testing.T) {
+	m := Manifest{
+		Name: "merge",
+		In:   []Port{{Name: "stdin", Type: TypeStream}, {Name: "inputs", Type: TypeStream}},
+		Out:  []Port{{Name: "stdout", Type: TypeStream}},
+		Args: []ArgSpec{{Name: "inputs", Variadic: true}},
+	}
+	proc := func(in []Port, out []Port, args ...Arg) Process {
+		return Process{Node: Node{Name: "p", In: in, Out: out}, Args: args}
+	}
+	a, b := &Port{Name: "a", Type: TypeStream}, &Port{Name: "b", Type: TypeStream}
+	sep := ArgString("-sep")
+	stdout := Port{Name: "stdout", Type: TypeStream}
+	stderr := Port{Name: "stderr", Type: TypeStream}
+
+	assert.NoError(t, m.Check(proc([]Port{*a, *b}, []Port{stdout, stderr}, &sep, a, b)))
+	assert.ErrorContains(t, m.Check(proc([]Port{*a}, []Port{stdout, *b}, a, b)), "passed as 'inputs' which is in stream, plan has out stream")
+	assert.ErrorContains(t, m.Check(proc([]Port{*a}, nil)), "neither stdio nor passed")
+	assert.ErrorContains(t, m.Check(proc(nil, []Port{{Name: "stdin", Type: TypeStream}})), "port 'stdin' is out stream")
+
+	// A string port passed as the value of a flag is not a positional argument
+	m.Flags = []FlagSpec{{Name: "sep", Type: "value"}, {Name: "v", Type: "bool"}}
+	sepPort := &Port{Name: "sep", Type: TypeString}
+	verbose := ArgString("-v")
+	assert.NoError(t, m.Check(proc([]Port{*sepPort, *a}, nil, &sep, sepPort, a)))
+	assert.NoError(t, m.Check(proc([]Port{*sepPort, *a}, nil, &verbose, a, &sep, sepPort)))
+	assert.NoError(t, m.Check(proc([]Port{*a, *b}, nil, &sep, b, a)))
+
+	m.Args[0].Variadic = false
+	assert.ErrorContains(t, m.Check(proc([]Port{*a, *b}, nil, a, b)), "extra argument")
+}
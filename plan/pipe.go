@@ -37,6 +37,13 @@ func sortNodes[T node](nodes []T) {
 	sort.Slice(nodes, func(i, j int) bool { return nodes[i].GetName() <= nodes[j].GetName() })
 }
 
+// Sort restores the order the Find* methods rely on after processes, variables or links were added.
+func (p *Pipe) Sort() {
+	sortNodes(p.Procs)
+	sortNodes(p.Vars)
+	sortLinks(p.Links)
+}
+
 func (p *Pipe) FindProc(name string) *Process {
 	return findNode(p.Procs, name)
 }
@@ -74,14 +81,14 @@ func (p *Pipe) FindLink(dst Ref) *Link {
 }
 
 type Port struct {
-	Name string
-	Type VarType
+	Name string  `json:"name"`
+	Type VarType `json:"type"`
 }
 
 type Node struct {
-	Name string
-	In   []Port
-	Out  []Port
+	Name string `json:"name"`
+	In   []Port `json:"in"`
+	Out  []Port `json:"out"`
 }
 
 func (n Node) GetName() string {
@@ -112,8 +119,9 @@ func (n Node) FindPort(name string) (*Port, PortDir) {
 
 type Process struct {
 	Node
-	Exe  string
-	Args []Arg
+	Exe    string
+	Args   []Arg
+	IsNode bool // The executable was written with package node: it answers --hoser-describe and reports events
 }
 
 type Variable struct {
@@ -138,7 +146,8 @@ type ArgString string
 func (s *ArgString) arg() {}
 
 type Ref struct {
-	Node, Port string
+	Node string `json:"node"`
+	Port string `json:"port"`
 }
 
 func (r Ref) String() string {
@@ -146,6 +155,10 @@ func (r Ref) String() string {
 }
 
 type Link struct {
-	Src Ref
-	Dst Ref
+	Src Ref `json:"src"`
+	Dst Ref `json:"dst"`
+
+	// Buffer, if not 0, is how many bytes of records are buffered on a stream link in memory before spilling to
+	// disk, so that the source never waits for a slow destination.
+	Buffer int64 `json:"buffer,omitempty"`
 }
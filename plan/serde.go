@@ -58,8 +58,9 @@ func unmarshalPipe(raw json.RawMessage, pipe *Pipe) error {
 func unmarshalProcess(raw json.RawMessage) (Process, error) {
 	var sp struct {
 		Node
-		Exe  string
-		Args []interface{}
+		Exe    string
+		Args   []interface{}
+		IsNode bool `json:"node"`
 	}
 	if err := json.Unmarshal(raw, &sp); err != nil {
 		return Process{}, err
@@ -82,5 +83,5 @@ func unmarshalProcess(raw json.RawMessage) (Process, error) {
 			return Process{}, fmt.Errorf("bad arg '%v' of type %T", rawArg, v)
 		}
 	}
-	return Process{Node: sp.Node, Exe: sp.Exe, Args: args}, nil
+	return Process{Node: sp.Node, Exe: sp.Exe, Args: args, IsNode: sp.IsNode}, nil
 }
@@ -0,0 +1,248 @@
+// Package spill is a FIFO queue of records that is kept in memory up to a limit and overflows to a temporary file,
+// so that a fast producer never has to wait for a slow consumer. Records keep their boundaries and their order.
+package spill
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/masp/hoser-runtime/node"
+)
+
+// ErrClosed is returned by Push after the queue was closed.
+var ErrClosed = errors.New("queue closed")
+
+// Queue is safe to use from one producer and one consumer at the same time.
+type Queue struct {
+	mu       sync.Mutex
+	ready    *sync.Cond
+	limit    int64  // The bytes kept in memory before spilling
+	dir      string // Where the spill file is created, "" for the default temporary directory
+	closed   bool
+	mem      [][]byte
+	memBytes int64
+
+	// Records that did not fit in memory are appended to file with a 4 byte big endian length prefix. Once a record
+	// is in the file, every following record goes there too until the file is read to the end, so the order is kept.
+	file       *os.File
+	wr         *bufio.Writer
+	readOff    int64
+	writeOff   int64
+	spilled    int   // Records in the file that were not popped yet
+	spillBytes int64 // Their size without the length prefix
+}
+
+// New creates a queue that keeps up to limit bytes of records in memory and spills the rest to a file in dir.
+func New(limit int64, dir string) *Queue {
+	q := &Queue{limit: limit, dir: dir}
+	q.ready = sync.NewCond(&q.mu)
+	return q
+}
+
+// Push adds a copy of the record to the end of the queue. It never blocks on the consumer.
+func (q *Queue) Push(record []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	size := int64(len(record))
+	if q.spilled == 0 && q.memBytes+size <= q.limit {
+		q.mem = append(q.mem, append([]byte(nil), record...))
+		q.memBytes += size
+		q.ready.Signal()
+		return nil
+	}
+	if err := q.spill(record); err != nil {
+		return fmt.Errorf("spill: %w", err)
+	}
+	q.ready.Signal()
+	return nil
+}
+
+func (q *Queue) spill(record []byte) error {
+	if q.file == nil {
+		f, err := os.CreateTemp(q.dir, "hoser-spill-")
+		if err != nil {
+			return err
+		}
+		os.Remove(f.Name()) // the file only lives as long as it is open
+		q.file = f
+		q.wr = bufio.NewWriter(&offsetWriter{f: f, off: &q.writeOff})
+	}
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(record)))
+	if _, err := q.wr.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := q.wr.Write(record); err != nil {
+		return err
+	}
+	q.spilled++
+	q.spillBytes += int64(len(record))
+	return nil
+}
+
+// Pop removes the record at the front of the queue, waiting for one to be pushed if the queue is empty. It returns
+// io.EOF once the queue is closed and empty.
+func (q *Queue) Pop() ([]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.mem) == 0 && q.spilled == 0 {
+		if q.closed {
+			return nil, io.EOF
+		}
+		q.ready.Wait()
+	}
+	if len(q.mem) > 0 {
+		record := q.mem[0]
+		q.mem[0] = nil
+		q.mem = q.mem[1:]
+		q.memBytes -= int64(len(record))
+		return record, nil
+	}
+	return q.unspill()
+}
+
+func (q *Queue) unspill() ([]byte, error) {
+	if err := q.wr.Flush(); err != nil {
+		return nil, fmt.Errorf("spill: %w", err)
+	}
+	var header [4]byte
+	if _, err := q.file.ReadAt(header[:], q.readOff); err != nil {
+		return nil, fmt.Errorf("unspill: %w", err)
+	}
+	record := make([]byte, binary.BigEndian.Uint32(header[:]))
+	if _, err := q.file.ReadAt(record, q.readOff+4); err != nil {
+		return nil, fmt.Errorf("unspill: %w", err)
+	}
+	q.readOff += 4 + int64(len(record))
+	q.spilled--
+	q.spillBytes -= int64(len(record))
+	if q.spilled == 0 {
+		// Everything was read, start over so the file does not grow forever
+		q.readOff, q.writeOff = 0, 0
+		if err := q.file.Truncate(0); err != nil {
+			return nil, fmt.Errorf("spill: %w", err)
+		}
+	}
+	return record, nil
+}
+
+// Close marks the end of the records. Pop returns the records that are left and then io.EOF.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.ready.Broadcast()
+}
+
+// Release removes the spill file. The queue cannot be used afterwards.
+func (q *Queue) Release() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.ready.Broadcast()
+	if q.file == nil {
+		return nil
+	}
+	return q.file.Close()
+}
+
+// Len returns the number of records in the queue and how many of them are spilled.
+func (q *Queue) Len() (records, spilled int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.mem) + q.spilled, q.spilled
+}
+
+// Bytes returns the size of the records in the queue and how many of those bytes are spilled.
+func (q *Queue) Bytes() (total, spilled int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.memBytes + q.spillBytes, q.spillBytes
+}
+
+// offsetWriter appends to a file at an offset that can be reset, so the file can be reused once it was read.
+type offsetWriter struct {
+	f   *os.File
+	off *int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, *w.off)
+	*w.off += int64(n)
+	return n, err
+}
+
+// Pump copies the records of rd to wr through q, reading as fast as rd allows however slowly wr is written. Every
+// record is written with a single WriteRecord. progress, if not nil, is called whenever a record is queued or
+// written with the totals written so far and the number of records queued; it may be called from two goroutines at
+// once. Pump returns once every record was written, on the first error or when ctx is done. Records still queued
+// when ctx is done are not written; a ReadRecord in progress may keep blocking in the background until rd returns.
+func Pump(ctx context.Context, rd node.RecordReader, wr node.RecordWriter, q *Queue, progress func(records, bytes, queued int64)) error {
+	var records, bytes int64
+	report := func() {
+		if progress != nil {
+			queued, _ := q.Len()
+			progress(atomic.LoadInt64(&records), atomic.LoadInt64(&bytes), int64(queued))
+		}
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		defer q.Close()
+		for ctx.Err() == nil {
+			record, err := rd.ReadRecord()
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				readErr <- err
+				return
+			}
+			if err := q.Push(record); err != nil {
+				readErr <- err
+				return
+			}
+			report()
+		}
+		readErr <- ctx.Err()
+	}()
+
+	// Closing the queue wakes up Pop, the reader can't be interrupted
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			q.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		record, err := q.Pop()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == io.EOF {
+			return <-readErr
+		} else if err != nil {
+			return err
+		}
+		if err := wr.WriteRecord(record); err != nil {
+			return err
+		}
+		atomic.AddInt64(&bytes, int64(len(record)))
+		atomic.AddInt64(&records, 1)
+		report()
+	}
+}
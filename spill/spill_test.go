@@ -0,0 +1,76 @@
+package spill
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/masp/hoser-runtime/node"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueue(t *testing.T) {
+	q := New(10, t.TempDir())
+	defer q.Release()
+
+	var want []string
+	push := func(count int) {
+		for i := 0; i < count; i++ {
+			rec := fmt.Sprintf("rec%d", len(want))
+			require.NoError(t, q.Push([]byte(rec)))
+			want = append(want, rec)
+		}
+	}
+	pop := func(count int) {
+		for i := 0; i < count; i++ {
+			rec, err := q.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, want[0], string(rec))
+			want = want[1:]
+		}
+	}
+
+	push(5) // 2 in memory, 3 spilled
+	records, spilled := q.Len()
+	assert.Equal(t, 5, records)
+	assert.Equal(t, 3, spilled)
+	pop(3)
+	push(2) // memory has room again but spilled records are still queued, so these spill too
+	_, spilled = q.Len()
+	assert.Equal(t, 4, spilled)
+	pop(4)
+	push(3) // the file was read to the end and is reused
+	q.Close()
+	assert.ErrorIs(t, q.Push([]byte("x")), ErrClosed)
+	pop(len(want))
+	_, err := q.Pop()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestPumpCancel(t *testing.T) {
+	q := New(10, t.TempDir())
+	defer q.Release()
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	var out bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- Pump(ctx, node.Delim('\n').NewReader(pr), node.Delim('\n').NewWriter(&out), q, nil)
+	}()
+	_, err := pw.Write([]byte("a\n")) // the reader now waits for more
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { n, _ := q.Len(); return n == 0 }, time.Second, time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("Pump did not return after the context was canceled")
+	}
+}